@@ -0,0 +1,252 @@
+package gql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// XHasuraElevationID is the session variable every request of an elevated client is sent with, so HGE logs can be
+// correlated with the audit events of the elevation
+const XHasuraElevationID = "x-hasura-elevation-id"
+
+// ErrElevationReasonRequired is returned when elevating a client without a reason, if the client was created
+// WithElevationReasonRequired
+var ErrElevationReasonRequired = errors.New("a reason is required to elevate the client")
+
+var (
+	// ErrElevationExpired is returned for the requests of an elevated client past its ElevationTTL
+	ErrElevationExpired = errors.New("elevation expired")
+	// ErrElevationRevoked is returned for the requests of an elevated client once its WithElevation scope is over
+	ErrElevationRevoked = errors.New("elevation revoked")
+	// ErrOperationNotElevated is returned for the requests of an elevated client for operations not listed in its
+	// ElevationOperations
+	ErrOperationNotElevated = errors.New("operation not allowed by the elevation")
+)
+
+// AuditEventType is the type of an AuditEvent
+type AuditEventType string
+
+const (
+	// AuditElevation is emitted when a client is elevated
+	AuditElevation AuditEventType = "elevation"
+	// AuditElevatedOperation is emitted for every request sent by an elevated, or impersonating, client
+	AuditElevatedOperation AuditEventType = "elevated_operation"
+	// AuditImpersonation is emitted when a client impersonates another actor
+	AuditImpersonation AuditEventType = "impersonation"
+	// AuditElevationDenied is emitted for the requests an elevated client refuses, because the elevation expired,
+	// was revoked, or doesn't allow the operation
+	AuditElevationDenied AuditEventType = "elevation_denied"
+)
+
+// AuditEvent is a structured record of an elevation, or of an operation run by an elevated client
+type AuditEvent struct {
+	Type AuditEventType
+	Time time.Time
+	// ElevationID identifies the elevation, and is sent to HGE as XHasuraElevationID
+	ElevationID string
+	// Actor is the actor of the client that was elevated, and Target the actor of the elevated client
+	Actor  *Actor
+	Target *Actor
+	Reason string
+	// Caller is the file, line and function that elevated the client
+	Caller string
+	// ExpiresAt is when the elevated client stops sending requests, zero if it doesn't expire
+	ExpiresAt time.Time
+	// OperationName is the operation sent by the elevated client, for AuditElevatedOperation events
+	OperationName string
+}
+
+// AuditSink receives audit events
+type AuditSink interface {
+	Audit(ctx context.Context, event AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to an AuditSink
+type AuditSinkFunc func(ctx context.Context, event AuditEvent)
+
+func (f AuditSinkFunc) Audit(ctx context.Context, event AuditEvent) {
+	f(ctx, event)
+}
+
+// WithAuditSink sends the audit events of the clients elevated from the client to the given sink
+func WithAuditSink(sink AuditSink) Option {
+	return func(opts *options) {
+		opts.auditSink = sink
+	}
+}
+
+// WithElevationReasonRequired makes AsAdmin, and hence ForceAdmin, fail with ErrElevationReasonRequired: the client
+// can only be elevated with Elevate and a reason.
+func WithElevationReasonRequired() Option {
+	return func(opts *options) {
+		opts.reasonRequired = true
+	}
+}
+
+// ElevationOption restricts an elevated client
+type ElevationOption func(*elevation)
+
+// ElevationTTL makes the elevated client refuse requests, with ErrElevationExpired, once the given time has passed
+func ElevationTTL(ttl time.Duration) ElevationOption {
+	return func(e *elevation) {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+}
+
+// ElevationOperations restricts the elevated client to the given operation names, refusing any other request with
+// ErrOperationNotElevated
+func ElevationOperations(operationNames ...string) ElevationOption {
+	return func(e *elevation) {
+		if e.operations == nil {
+			e.operations = map[string]bool{}
+		}
+		for _, name := range operationNames {
+			e.operations[name] = true
+		}
+	}
+}
+
+// elevation describes how a client was derived with admin privileges, and the restrictions of the elevated client
+type elevation struct {
+	id     string
+	actor  *Actor
+	target *Actor
+	reason string
+	caller string
+	sink   AuditSink
+
+	expiresAt  time.Time
+	operations map[string]bool
+	revoked    atomic.Bool
+	// done revokes the elevation once closed
+	done <-chan struct{}
+	// parent is the elevation of the client this one was elevated from, whose restrictions apply too
+	parent *elevation
+	// impersonatedBy identifies the impersonating actor, for impersonations
+	impersonatedBy string
+}
+
+func newElevation(reason, caller string, options []ElevationOption) *elevation {
+	e := &elevation{reason: reason, caller: caller}
+	for _, apply := range options {
+		apply(e)
+	}
+	return e
+}
+
+// allows checks the elevation, and the ones it derives from, allow sending the operation
+func (e *elevation) allows(now time.Time, operationName string) error {
+	for ; e != nil; e = e.parent {
+		switch {
+		case e.revoked.Load() || isClosed(e.done):
+			return ErrElevationRevoked
+		case !e.expiresAt.IsZero() && !now.Before(e.expiresAt):
+			return ErrElevationExpired
+		case e.operations != nil && !e.operations[operationName]:
+			return fmt.Errorf("%w: %q", ErrOperationNotElevated, operationName)
+		}
+	}
+	return nil
+}
+
+// impersonator returns who is impersonating through the elevation, or the ones it derives from, if anyone
+func (e *elevation) impersonator() string {
+	for ; e != nil; e = e.parent {
+		if e.impersonatedBy != "" {
+			return e.impersonatedBy
+		}
+	}
+	return ""
+}
+
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *elevation) audit(ctx context.Context, eventType AuditEventType, operationName string) {
+	if e.sink == nil {
+		return
+	}
+	e.sink.Audit(ctx, AuditEvent{
+		Type:          eventType,
+		Time:          time.Now(),
+		ElevationID:   e.id,
+		Actor:         e.actor,
+		Target:        e.target,
+		Reason:        e.reason,
+		Caller:        e.caller,
+		ExpiresAt:     e.expiresAt,
+		OperationName: operationName,
+	})
+}
+
+// callerSite describes the caller of the function calling callerSite, skipping the given number of extra frames
+func callerSite(skip int) string {
+	pc, file, line, ok := runtime.Caller(skip + 2)
+	if !ok {
+		return "unknown"
+	}
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		return fmt.Sprintf("%s:%d %s", file, line, fn.Name())
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// Elevate allows the client to act on behalf of an admin, recording the reason in the audit events of the elevation.
+// The elevated client can be restricted with ElevationTTL and ElevationOperations. It returns an error in case the
+// client is not promotable.
+func (c *ActorAwareClient) Elevate(reason string, options ...ElevationOption) (*ActorAwareClient, error) {
+	return c.elevate(reason, options...)
+}
+
+// elevate derives an admin client, auditing the caller of the exported method calling it
+func (c *ActorAwareClient) elevate(reason string, options ...ElevationOption) (*ActorAwareClient, error) {
+	return c.sudoFunc(c.Actor.AsAdmin(), newElevation(reason, callerSite(1), options))
+}
+
+// WithElevation calls fn with an admin client elevated from client, which refuses requests with ErrElevationRevoked
+// once fn returns or ctx is done, so admin privileges can't outlive the task needing them:
+//
+//	err := gql.WithElevation(ctx, client, "recompute project quotas", func(admin *gql.ActorAwareClient) error {
+//		return admin.NamedMutate(ctx, "RecomputeQuotas", &m, vars)
+//	}, gql.ElevationOperations("RecomputeQuotas"))
+func WithElevation(ctx context.Context, client *ActorAwareClient, reason string, fn func(admin *ActorAwareClient) error, options ...ElevationOption) error {
+	e := newElevation(reason, callerSite(0), options)
+	e.done = ctx.Done()
+	admin, err := client.sudoFunc(client.Actor.AsAdmin(), e)
+	if err != nil {
+		return err
+	}
+
+	defer e.revoked.Store(true)
+	return fn(admin)
+}
+
+type elevationRoundTripper struct {
+	elevation *elevation
+	rt        http.RoundTripper
+}
+
+// RoundTrip audits the operation sent by the elevated client, refusing it if the elevation doesn't allow it
+func (e elevationRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, _, err := readPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.elevation.allows(time.Now(), p.OperationName); err != nil {
+		e.elevation.audit(req.Context(), AuditElevationDenied, p.OperationName)
+		return nil, err
+	}
+	e.elevation.audit(req.Context(), AuditElevatedOperation, p.OperationName)
+	return e.rt.RoundTrip(req)
+}
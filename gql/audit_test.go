@@ -0,0 +1,141 @@
+package gql
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (l *auditLog) Audit(ctx context.Context, event AuditEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func TestElevationAudit(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	sampleUUID := uuid.New()
+	actor := NewUserActor(&sampleUUID, "foo@bar.baz")
+	log := &auditLog{}
+	cl := NewPromotableClient(ts.Endpoint(), "admin-secret", actor, "test-client", WithAuditSink(log))
+
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Empty(t, log.events)
+
+	admin, err := cl.Elevate("support ticket 42")
+	assert.NoError(t, err)
+	assert.NoError(t, admin.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.NoError(t, cl.ForceAdmin().NamedMutate(context.TODO(), "UpdateThing", &query, nil))
+
+	if assert.Len(t, log.events, 4) {
+		elevation := log.events[0]
+		assert.Equal(t, AuditElevation, elevation.Type)
+		assert.Equal(t, actor, elevation.Actor)
+		assert.Equal(t, admin.Actor, elevation.Target)
+		assert.Equal(t, "support ticket 42", elevation.Reason)
+		assert.Contains(t, elevation.Caller, "audit_test.go")
+		assert.Contains(t, elevation.Caller, "TestElevationAudit")
+
+		operation := log.events[1]
+		assert.Equal(t, AuditElevatedOperation, operation.Type)
+		assert.Equal(t, elevation.ElevationID, operation.ElevationID)
+		assert.Equal(t, "GetThing", operation.OperationName)
+
+		assert.Equal(t, AuditElevation, log.events[2].Type)
+		assert.Empty(t, log.events[2].Reason)
+		assert.Contains(t, log.events[2].Caller, "audit_test.go")
+		assert.Equal(t, "UpdateThing", log.events[3].OperationName)
+		assert.NotEqual(t, elevation.ElevationID, log.events[3].ElevationID)
+
+		requests := ts.RequestsFor("GetThing")
+		assert.Empty(t, requests[0].Headers.Get(XHasuraElevationID))
+		assert.Equal(t, elevation.ElevationID, requests[1].Headers.Get(XHasuraElevationID))
+	}
+}
+
+func TestElevationReasonRequired(t *testing.T) {
+	sampleUUID := uuid.New()
+	cl := NewPromotableClient("http://localhost/v1/graphql", "admin-secret", NewUserActor(&sampleUUID, "foo@bar.baz"), "test-client",
+		WithElevationReasonRequired())
+
+	_, err := cl.AsAdmin()
+	assert.ErrorIs(t, err, ErrElevationReasonRequired)
+	assert.Panics(t, func() { cl.ForceAdmin() })
+	admin, err := cl.Elevate("nightly cleanup")
+	assert.NoError(t, err)
+	assert.True(t, admin.Actor.IsAdmin())
+
+	_, err = NewClient("http://localhost/v1/graphql", "admin-secret", NewUserActor(&sampleUUID, "foo@bar.baz"), "test-client").
+		Elevate("nightly cleanup")
+	assert.Error(t, err)
+}
+
+func TestScopedElevation(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	sampleUUID := uuid.New()
+	log := &auditLog{}
+	cl := NewPromotableClient(ts.Endpoint(), "admin-secret", NewUserActor(&sampleUUID, "foo@bar.baz"), "test-client", WithAuditSink(log))
+
+	var leaked *ActorAwareClient
+	err := WithElevation(context.TODO(), cl, "recompute quotas", func(admin *ActorAwareClient) error {
+		leaked = admin
+		assert.ErrorIs(t, admin.NamedQuery(context.TODO(), "OtherThing", &query, nil), ErrOperationNotElevated)
+		// clients elevated from a restricted one keep its restrictions
+		nested, err := admin.Elevate("nested")
+		assert.NoError(t, err)
+		assert.ErrorIs(t, nested.NamedQuery(context.TODO(), "OtherThing", &query, nil), ErrOperationNotElevated)
+		return admin.NamedQuery(context.TODO(), "GetThing", &query, nil)
+	}, ElevationOperations("GetThing"))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, leaked.NamedQuery(context.TODO(), "GetThing", &query, nil), ErrElevationRevoked)
+	ts.AssertNotCalled(t, "OtherThing")
+	assert.Len(t, ts.RequestsFor("GetThing"), 1)
+
+	var types []AuditEventType
+	for _, e := range log.events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []AuditEventType{
+		AuditElevation, AuditElevationDenied,
+		AuditElevation, AuditElevationDenied,
+		AuditElevatedOperation, AuditElevationDenied,
+	}, types)
+	assert.Contains(t, log.events[0].Caller, "TestScopedElevation")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = WithElevation(ctx, cl, "canceled", func(admin *ActorAwareClient) error {
+		cancel()
+		return admin.NamedQuery(context.TODO(), "GetThing", &query, nil)
+	})
+	assert.ErrorIs(t, err, ErrElevationRevoked)
+
+	expiring, err := cl.Elevate("short task", ElevationTTL(time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, log.events[len(log.events)-1].ExpiresAt.IsZero())
+	time.Sleep(2 * time.Millisecond)
+	assert.ErrorIs(t, expiring.NamedQuery(context.TODO(), "GetThing", &query, nil), ErrElevationExpired)
+}
@@ -0,0 +1,338 @@
+// Package breaker stops sending requests to HGE while it's failing, so callers fail fast with ErrCircuitOpen instead
+// of piling up waiting for the client timeout.
+//
+//	client := breaker.New(gql.NewClient(endpoint, secret, actor, "my-service"),
+//		breaker.WithFailureRate(0.5),
+//		breaker.WithSlowCallThreshold(2*time.Second),
+//		breaker.WithOnStateChange(func(name string, from, to breaker.State) {
+//			log.Printf("circuit %q went from %s to %s", name, from, to)
+//		}),
+//	)
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrCircuitOpen is returned, without sending the request, while the circuit is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit
+type State int
+
+const (
+	// Closed circuits let every request through, counting failures
+	Closed State = iota
+	// Open circuits reject every request with ErrCircuitOpen
+	Open
+	// HalfOpen circuits let a few probe requests through, to decide whether to close or open again
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Counts are the outcomes of the requests made in the current window of a closed circuit, or since a circuit became
+// half-open
+type Counts struct {
+	Requests  int
+	Failures  int
+	Slow      int
+	Successes int
+}
+
+type options struct {
+	window        time.Duration
+	minRequests   int
+	failureRate   float64
+	slowThreshold time.Duration
+	openTimeout   time.Duration
+	probes        int
+	perOperation  bool
+	isFailure     func(error) bool
+	onStateChange func(name string, from, to State)
+	now           func() time.Time
+}
+
+func newOptions() options {
+	return options{
+		window:      10 * time.Second,
+		minRequests: 20,
+		failureRate: 0.5,
+		openTimeout: 30 * time.Second,
+		probes:      1,
+		isFailure:   IsTransportFailure,
+		now:         time.Now,
+	}
+}
+
+type Option func(*options)
+
+// WithWindow sets the duration over which the failure rate of a closed circuit is computed, 10 seconds by default
+func WithWindow(window time.Duration) Option {
+	return func(opts *options) {
+		opts.window = window
+	}
+}
+
+// WithMinRequests sets how many requests a window needs before the circuit can open, 20 by default
+func WithMinRequests(n int) Option {
+	return func(opts *options) {
+		opts.minRequests = n
+	}
+}
+
+// WithFailureRate sets the ratio of failed or slow requests in a window that opens the circuit, 0.5 by default. With a
+// rate of 0, any failed or slow request opens it.
+func WithFailureRate(rate float64) Option {
+	return func(opts *options) {
+		opts.failureRate = rate
+	}
+}
+
+// WithSlowCallThreshold counts successful requests lasting longer than the given duration as failures. Slow calls
+// aren't counted by default.
+func WithSlowCallThreshold(d time.Duration) Option {
+	return func(opts *options) {
+		opts.slowThreshold = d
+	}
+}
+
+// WithOpenTimeout sets how long a circuit stays open before letting probe requests through, 30 seconds by default
+func WithOpenTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.openTimeout = d
+	}
+}
+
+// WithProbes sets how many requests a half-open circuit lets through, all of which must succeed for the circuit to
+// close, 1 by default
+func WithProbes(n int) Option {
+	return func(opts *options) {
+		opts.probes = n
+	}
+}
+
+// WithPerOperation uses a circuit per operation name, so a failing operation doesn't stop the others. By default a
+// single circuit is used for all the requests of the client.
+func WithPerOperation() Option {
+	return func(opts *options) {
+		opts.perOperation = true
+	}
+}
+
+// WithIsFailure overrides which errors count as failures, IsTransportFailure by default
+func WithIsFailure(isFailure func(error) bool) Option {
+	return func(opts *options) {
+		opts.isFailure = isFailure
+	}
+}
+
+// WithOnStateChange calls the given function every time a circuit changes state. name is the operation name of the
+// circuit when using WithPerOperation, and empty otherwise.
+func WithOnStateChange(onStateChange func(name string, from, to State)) Option {
+	return func(opts *options) {
+		opts.onStateChange = onStateChange
+	}
+}
+
+// WithClock overrides the function used to get the current time, for tests
+func WithClock(now func() time.Time) Option {
+	return func(opts *options) {
+		opts.now = now
+	}
+}
+
+// callerErrors are reported by go-graphql-client as request errors, but are returned by the client before sending
+// the request, or because the caller gave up: they say nothing about the endpoint.
+var callerErrors = []error{
+	context.Canceled,
+	gql.ErrHeaderNotAllowed,
+	gql.ErrRoleNotAllowed,
+	gql.ErrElevationExpired,
+	gql.ErrElevationRevoked,
+	gql.ErrOperationNotElevated,
+}
+
+// IsTransportFailure reports whether the error means HGE couldn't be reached or didn't answer in time. GraphQL errors
+// returned by HGE, such as validation or permission errors, aren't failures of the endpoint, nor are the errors of
+// requests the client refused to send or the caller cancelled.
+func IsTransportFailure(err error) bool {
+	for _, callerErr := range callerErrors {
+		if errors.Is(err, callerErr) {
+			return false
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var errs graphql.Errors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			if code, _ := e.Extensions["code"].(string); code == graphql.ErrRequestError {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type circuit struct {
+	name string
+	opts *options
+	mu   sync.Mutex
+
+	state       State
+	counts      Counts
+	windowStart time.Time
+	openedAt    time.Time
+	// generation changes with the state, so outcomes of requests allowed in a previous state are ignored
+	generation uint64
+	inFlight   int
+}
+
+// allow reports whether a request can be sent, moving an open circuit to half-open once its timeout is over
+func (c *circuit) allow() (uint64, error) {
+	var changes []transition
+	defer c.notify(&changes)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.opts.now()
+	switch c.state {
+	case Closed:
+		if now.Sub(c.windowStart) >= c.opts.window {
+			c.counts = Counts{}
+			c.windowStart = now
+		}
+	case Open:
+		if now.Sub(c.openedAt) < c.opts.openTimeout {
+			return 0, ErrCircuitOpen
+		}
+		c.setState(HalfOpen, now, &changes)
+		fallthrough
+	case HalfOpen:
+		if c.inFlight+c.counts.Requests >= c.opts.probes {
+			return 0, ErrCircuitOpen
+		}
+	}
+	c.inFlight++
+	return c.generation, nil
+}
+
+// abandon forgets a request allowed by allow whose caller gave up, without recording any outcome
+func (c *circuit) abandon(generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.inFlight--
+	}
+}
+
+// done records the outcome of a request allowed by allow
+func (c *circuit) done(generation uint64, failed, slow bool) {
+	var changes []transition
+	defer c.notify(&changes)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	now := c.opts.now()
+	c.inFlight--
+	c.counts.Requests++
+	switch {
+	case failed:
+		c.counts.Failures++
+	case slow:
+		c.counts.Slow++
+	default:
+		c.counts.Successes++
+	}
+
+	switch c.state {
+	case Closed:
+		bad := c.counts.Failures + c.counts.Slow
+		// at least one request must have failed, as any ratio reaches a rate of 0
+		if c.counts.Requests >= c.opts.minRequests && bad > 0 && float64(bad) >= c.opts.failureRate*float64(c.counts.Requests) {
+			c.setState(Open, now, &changes)
+		}
+	case HalfOpen:
+		if failed || slow {
+			c.setState(Open, now, &changes)
+		} else if c.counts.Successes >= c.opts.probes {
+			c.setState(Closed, now, &changes)
+		}
+	}
+}
+
+type transition struct {
+	from, to State
+}
+
+func (c *circuit) setState(state State, now time.Time, changes *[]transition) {
+	*changes = append(*changes, transition{from: c.state, to: state})
+	c.state = state
+	c.counts = Counts{}
+	c.windowStart = now
+	c.generation++
+	c.inFlight = 0
+	if state == Open {
+		c.openedAt = now
+	}
+}
+
+// notify calls the state change callback once the circuit is unlocked, so the callback can use the client
+func (c *circuit) notify(changes *[]transition) {
+	if c.opts.onStateChange == nil {
+		return
+	}
+	for _, t := range *changes {
+		c.opts.onStateChange(c.name, t.from, t.to)
+	}
+}
+
+func (c *circuit) snapshot() (State, Counts) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state, c.counts
+}
+
+type metrics struct {
+	requests metric.Int64Counter
+}
+
+func newMetrics() metrics {
+	requests, _ := otel.Meter("github.com/hasura/hge-go-gql-client/gql/breaker").Int64Counter(
+		"hasura.circuit_breaker.requests",
+		metric.WithDescription("Requests going through a circuit breaker, by operation and result (success, failure, slow, rejected or abandoned)"),
+	)
+	return metrics{requests: requests}
+}
+
+func (m metrics) record(ctx context.Context, operationName, result string) {
+	m.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("operation", operationName),
+		attribute.String("result", result),
+	))
+}
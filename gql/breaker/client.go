@@ -0,0 +1,148 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+)
+
+// Client is a gql.Client guarded by a circuit breaker. As a gql.Client sends all its requests to one endpoint, a
+// Client has one circuit for the endpoint, or one per operation name with WithPerOperation.
+//
+// A closed circuit opens when, over a window with enough requests, the rate of failed or slow requests reaches the
+// failure rate. It then rejects every request with ErrCircuitOpen until the open timeout is over, and becomes
+// half-open: the next probe requests are let through, closing the circuit if they all succeed or opening it again as
+// soon as one fails.
+type Client struct {
+	cl      gql.Client
+	opts    options
+	metrics metrics
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// New wraps the given client with a circuit breaker
+func New(cl gql.Client, options ...Option) *Client {
+	opts := newOptions()
+	for _, apply := range options {
+		apply(&opts)
+	}
+
+	return &Client{
+		cl:       cl,
+		opts:     opts,
+		metrics:  newMetrics(),
+		circuits: map[string]*circuit{},
+	}
+}
+
+// State returns the state of the circuit for the given operation name, which is ignored without WithPerOperation. An
+// open circuit is reported as such until a request is made after its open timeout.
+func (c *Client) State(operationName string) State {
+	state, _ := c.circuit(operationName).snapshot()
+	return state
+}
+
+// Counts returns the counts of the circuit for the given operation name, which is ignored without WithPerOperation
+func (c *Client) Counts(operationName string) Counts {
+	_, counts := c.circuit(operationName).snapshot()
+	return counts
+}
+
+func (c *Client) circuit(operationName string) *circuit {
+	if !c.opts.perOperation {
+		operationName = ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cc, ok := c.circuits[operationName]
+	if !ok {
+		cc = &circuit{name: operationName, opts: &c.opts, windowStart: c.opts.now()}
+		c.circuits[operationName] = cc
+	}
+	return cc
+}
+
+// do runs the request if the circuit of the operation allows it, and records its outcome
+func (c *Client) do(ctx context.Context, operationName string, request func() error) error {
+	cc := c.circuit(operationName)
+	generation, err := cc.allow()
+	if err != nil {
+		c.metrics.record(ctx, operationName, "rejected")
+		return err
+	}
+
+	start := c.opts.now()
+	err = request()
+	elapsed := c.opts.now().Sub(start)
+
+	// the caller's own deadline or cancellation isn't an outcome of the endpoint
+	if ctx.Err() != nil {
+		cc.abandon(generation)
+		c.metrics.record(ctx, operationName, "abandoned")
+		return err
+	}
+
+	failed := err != nil && c.opts.isFailure(err)
+	slow := !failed && c.opts.slowThreshold > 0 && elapsed > c.opts.slowThreshold
+	cc.done(generation, failed, slow)
+
+	switch {
+	case failed:
+		c.metrics.record(ctx, operationName, "failure")
+	case slow:
+		c.metrics.record(ctx, operationName, "slow")
+	default:
+		c.metrics.record(ctx, operationName, "success")
+	}
+	return err
+}
+
+func (c *Client) Query(ctx context.Context, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, "", func() error {
+		return c.cl.Query(ctx, q, variables, options...)
+	})
+}
+
+func (c *Client) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, name, func() error {
+		return c.cl.NamedQuery(ctx, name, q, variables, options...)
+	})
+}
+
+func (c *Client) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	var data []byte
+	err := c.do(ctx, name, func() (err error) {
+		data, err = c.cl.NamedQueryRaw(ctx, name, q, variables, options...)
+		return err
+	})
+	return data, err
+}
+
+func (c *Client) Mutate(ctx context.Context, m interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, "", func() error {
+		return c.cl.Mutate(ctx, m, variables, options...)
+	})
+}
+
+func (c *Client) NamedMutate(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, name, func() error {
+		return c.cl.NamedMutate(ctx, name, m, variables, options...)
+	})
+}
+
+func (c *Client) NamedMutateRaw(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	var data []byte
+	err := c.do(ctx, name, func() (err error) {
+		data, err = c.cl.NamedMutateRaw(ctx, name, m, variables, options...)
+		return err
+	})
+	return data, err
+}
+
+// assert that *Client implements gql.Client
+var _ gql.Client = &Client{}
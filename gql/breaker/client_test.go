@@ -0,0 +1,184 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// stub answers named queries with the error, taking the latency on the clock
+type stub struct {
+	gql.Client
+	clock   *clock
+	err     error
+	latency time.Duration
+	calls   int
+}
+
+func (s *stub) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	s.calls++
+	s.clock.Advance(s.latency)
+	return s.err
+}
+
+var requestError = graphql.Errors{{Message: "connection refused", Extensions: map[string]interface{}{"code": graphql.ErrRequestError}}}
+
+func TestBreaker(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s := &stub{clock: clk}
+	var changes []string
+	cl := New(s, WithClock(clk.Now), WithMinRequests(4), WithFailureRate(0.5), WithOpenTimeout(time.Minute),
+		WithOnStateChange(func(name string, from, to State) {
+			changes = append(changes, from.String()+" -> "+to.String())
+		}))
+
+	// graphql errors returned by HGE don't count
+	s.err = graphql.Errors{{Message: "not found", Extensions: map[string]interface{}{"code": "validation-failed"}}}
+	for i := 0; i < 4; i++ {
+		assert.Error(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	}
+	assert.Equal(t, Closed, cl.State("GetThing"))
+	assert.Equal(t, Counts{Requests: 4, Successes: 4}, cl.Counts("GetThing"))
+
+	// a new window starts, where half the requests fail
+	clk.Advance(time.Minute)
+	s.err = nil
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	s.err = requestError
+	assert.Error(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Closed, cl.State("GetThing"))
+	assert.Error(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Open, cl.State("GetThing"))
+
+	calls := s.calls
+	err := cl.NamedQuery(context.TODO(), "OtherThing", nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, calls, s.calls)
+
+	// after the timeout a failing probe opens the circuit again, and a successful one closes it
+	clk.Advance(time.Minute)
+	assert.Equal(t, requestError, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Open, cl.State("GetThing"))
+	assert.ErrorIs(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil), ErrCircuitOpen)
+
+	clk.Advance(time.Minute)
+	s.err = nil
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Closed, cl.State("GetThing"))
+
+	assert.Equal(t, []string{
+		"closed -> open",
+		"open -> half-open", "half-open -> open",
+		"open -> half-open", "half-open -> closed",
+	}, changes)
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s := &stub{clock: clk, latency: 3 * time.Second}
+	cl := New(s, WithClock(clk.Now), WithMinRequests(2), WithSlowCallThreshold(2*time.Second), WithPerOperation())
+
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "Slow", nil, nil))
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "Slow", nil, nil))
+	assert.Equal(t, Open, cl.State("Slow"))
+
+	// other operations have circuits of their own
+	s.latency = 0
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "Fast", nil, nil))
+	assert.Equal(t, Closed, cl.State("Fast"))
+}
+
+func TestBreakerZeroFailureRate(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s := &stub{clock: clk}
+	cl := New(s, WithClock(clk.Now), WithMinRequests(2), WithFailureRate(0))
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	}
+	assert.Equal(t, Closed, cl.State("GetThing"))
+
+	s.err = requestError
+	assert.Error(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Open, cl.State("GetThing"))
+}
+
+func TestBreakerProbes(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s := &stub{clock: clk, err: context.DeadlineExceeded}
+	cl := New(s, WithClock(clk.Now), WithMinRequests(1), WithOpenTimeout(time.Second), WithProbes(2))
+
+	assert.Error(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Open, cl.State(""))
+
+	clk.Advance(time.Second)
+	s.err = nil
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, HalfOpen, cl.State(""))
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	assert.Equal(t, Closed, cl.State(""))
+
+	assert.True(t, IsTransportFailure(context.DeadlineExceeded))
+	assert.False(t, IsTransportFailure(errors.New("other")))
+}
+
+// failingTransport fails every round trip with the error
+type failingTransport struct {
+	err error
+}
+
+func (f failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, f.err
+}
+
+func TestBreakerIgnoresCallerErrors(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s := &stub{clock: clk}
+	cl := New(s, WithClock(clk.Now), WithMinRequests(2), WithFailureRate(0.5))
+
+	// errors the client returns before sending the request are wrapped as request errors too
+	for _, err := range []error{gql.ErrHeaderNotAllowed, gql.ErrRoleNotAllowed, gql.ErrElevationRevoked, context.Canceled} {
+		client := gql.NewClient("http://localhost/v1/graphql", "secret", gql.NewAdminActor(), "test-client", gql.WithRoundTripper(failingTransport{err}))
+		s.err = client.NamedQuery(context.TODO(), "GetThing", &struct{}{}, nil)
+		assert.ErrorIs(t, s.err, err)
+		assert.False(t, IsTransportFailure(s.err), err)
+		assert.Error(t, cl.NamedQuery(context.TODO(), "GetThing", nil, nil))
+	}
+	assert.Equal(t, Closed, cl.State(""))
+	assert.Equal(t, Counts{Requests: 4, Successes: 4}, cl.Counts(""))
+
+	// nor are the requests whose caller gave up
+	s.err = requestError
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	for i := 0; i < 3; i++ {
+		assert.Error(t, cl.NamedQuery(ctx, "GetThing", nil, nil))
+	}
+	assert.Equal(t, Closed, cl.State(""))
+	assert.Equal(t, Counts{Requests: 4, Successes: 4}, cl.Counts(""))
+}
@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+)
+
+type options struct {
+	store      Store
+	defaultTTL time.Duration
+	ttls       map[string]time.Duration
+	tags       map[string][]string
+	swr        time.Duration
+	mutations  map[string][]string
+	now        func() time.Time
+}
+
+func newOptions() options {
+	return options{
+		ttls:      map[string]time.Duration{},
+		tags:      map[string][]string{},
+		mutations: map[string][]string{},
+		now:       time.Now,
+	}
+}
+
+type Option func(*options)
+
+// WithStore overrides the default store, an LRU holding 1000 entries
+func WithStore(store Store) Option {
+	return func(opts *options) {
+		opts.store = store
+	}
+}
+
+// WithDefaultTTL caches every query not configured with WithOperationTTL for the given duration. By default only
+// the operations configured with WithOperationTTL are cached.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.defaultTTL = ttl
+	}
+}
+
+// WithOperationTTL caches the results of the named query for the given duration. A zero ttl disables caching for
+// the operation even when a default TTL is set.
+func WithOperationTTL(operationName string, ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.ttls[operationName] = ttl
+	}
+}
+
+// WithOperationTags tags the cached results of the named query, so they can be invalidated together with
+// InvalidateTags or WithMutationInvalidates.
+func WithOperationTags(operationName string, tags ...string) Option {
+	return func(opts *options) {
+		opts.tags[operationName] = append(opts.tags[operationName], tags...)
+	}
+}
+
+// WithStaleWhileRevalidate allows serving expired results for up to the given duration past their expiration, while
+// they're refreshed in the background.
+func WithStaleWhileRevalidate(d time.Duration) Option {
+	return func(opts *options) {
+		opts.swr = d
+	}
+}
+
+// WithMutationInvalidates invalidates the given tags every time the named mutation succeeds. Use OperationTag to
+// invalidate all the results of a given query.
+func WithMutationInvalidates(mutationName string, tags ...string) Option {
+	return func(opts *options) {
+		opts.mutations[mutationName] = append(opts.mutations[mutationName], tags...)
+	}
+}
+
+// OperationTag is the tag every cached result of the given operation is stored with
+func OperationTag(operationName string) string {
+	return "operation:" + operationName
+}
+
+// Client is a gql.Client caching query results. Cache keys include the role, user id and email of the actor the
+// client acts on behalf of, together with the headers set in the context, so a cached result is never served to
+// another actor. Mutations are never cached.
+type Client struct {
+	cl    *gql.ActorAwareClient
+	opts  options
+	store Store
+
+	refreshing sync.Map
+}
+
+// New wraps the given client, caching its query results
+func New(cl *gql.ActorAwareClient, options ...Option) *Client {
+	opts := newOptions()
+	for _, apply := range options {
+		apply(&opts)
+	}
+	if opts.store == nil {
+		opts.store = NewLRU(1000)
+	}
+
+	return &Client{
+		cl:    cl,
+		opts:  opts,
+		store: opts.store,
+	}
+}
+
+// Invalidate removes all the cached results of the given operations
+func (c *Client) Invalidate(operationNames ...string) {
+	tags := make([]string, len(operationNames))
+	for i, name := range operationNames {
+		tags[i] = OperationTag(name)
+	}
+	c.store.InvalidateTags(tags...)
+}
+
+// InvalidateTags removes all the cached results tagged with any of the given tags
+func (c *Client) InvalidateTags(tags ...string) {
+	c.store.InvalidateTags(tags...)
+}
+
+func (c *Client) Query(ctx context.Context, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.NamedQuery(ctx, "", q, variables, options...)
+}
+
+func (c *Client) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	data, err := c.NamedQueryRaw(ctx, name, q, variables, options...)
+	if err != nil {
+		return err
+	}
+	return graphql.UnmarshalGraphQL(data, q)
+}
+
+func (c *Client) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	ttl := c.ttl(name)
+	if ttl <= 0 {
+		return c.cl.NamedQueryRaw(ctx, name, q, variables, options...)
+	}
+
+	key, err := c.key(ctx, name, q, variables, options)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.opts.now()
+	if entry, ok := c.store.Get(key); ok {
+		switch {
+		case now.Before(entry.FreshUntil):
+			return entry.Data, nil
+		case now.Before(entry.StaleUntil):
+			c.revalidate(ctx, key, name, q, variables, options)
+			return entry.Data, nil
+		}
+	}
+
+	return c.fetch(ctx, key, name, q, variables, options)
+}
+
+func (c *Client) Mutate(ctx context.Context, m interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.cl.Mutate(ctx, m, variables, options...)
+}
+
+func (c *Client) NamedMutate(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	err := c.cl.NamedMutate(ctx, name, m, variables, options...)
+	if err == nil {
+		c.invalidateAfter(name)
+	}
+	return err
+}
+
+func (c *Client) NamedMutateRaw(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	data, err := c.cl.NamedMutateRaw(ctx, name, m, variables, options...)
+	if err == nil {
+		c.invalidateAfter(name)
+	}
+	return data, err
+}
+
+func (c *Client) invalidateAfter(mutationName string) {
+	if tags := c.opts.mutations[mutationName]; len(tags) > 0 {
+		c.store.InvalidateTags(tags...)
+	}
+}
+
+func (c *Client) ttl(operationName string) time.Duration {
+	if ttl, ok := c.opts.ttls[operationName]; ok {
+		return ttl
+	}
+	return c.opts.defaultTTL
+}
+
+func (c *Client) fetch(ctx context.Context, key, name string, q interface{}, variables map[string]interface{}, options []graphql.Option) ([]byte, error) {
+	data, err := c.cl.NamedQueryRaw(ctx, name, q, variables, options...)
+	if err != nil {
+		return data, err
+	}
+
+	freshUntil := c.opts.now().Add(c.ttl(name))
+	c.store.Set(key, Entry{
+		Data:       data,
+		Tags:       append([]string{OperationTag(name)}, c.opts.tags[name]...),
+		FreshUntil: freshUntil,
+		StaleUntil: freshUntil.Add(c.opts.swr),
+	})
+	return data, nil
+}
+
+// revalidate refreshes the entry in the background, unless it's already being refreshed
+func (c *Client) revalidate(ctx context.Context, key, name string, q interface{}, variables map[string]interface{}, options []graphql.Option) {
+	if _, loaded := c.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	// the caller keeps using q, so the refresh builds the query from a value of its own
+	fresh := reflect.New(reflect.TypeOf(q).Elem()).Interface()
+	go func() {
+		defer c.refreshing.Delete(key)
+		_, _ = c.fetch(context.WithoutCancel(ctx), key, name, fresh, variables, options)
+	}()
+}
+
+// key identifies a query made by the client's actor, using the given context headers, document and variables
+func (c *Client) key(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options []graphql.Option) (string, error) {
+	document, err := graphql.ConstructQuery(q, variables, append(options, graphql.OperationName(name))...)
+	if err != nil {
+		return "", err
+	}
+	vars, err := json.Marshal(variables)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if actor := c.cl.Actor; actor != nil {
+		_, _ = io.WriteString(h, "role="+actor.Role+"\n")
+		if actor.UserID != nil {
+			_, _ = io.WriteString(h, "user="+actor.UserID.String()+"\n")
+		}
+		_, _ = io.WriteString(h, "email="+actor.Email+"\n")
+		_, _ = io.WriteString(h, "allowed-roles="+strings.Join(actor.AllowedRoles, ",")+"\n")
+
+		names := make([]string, 0, len(actor.SessionVariables))
+		for name := range actor.SessionVariables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			_, _ = io.WriteString(h, "session "+name+"="+actor.SessionVariables[name]+"\n")
+		}
+	}
+
+	writeHeaders(h, "", gql.HeadersFromContext(ctx))
+	// call options override the context headers, and the role
+	writeHeaders(h, "call ", gql.CallHeadersFromContext(ctx))
+
+	_, _ = io.WriteString(h, document+"\n")
+	_, _ = h.Write(vars)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeHeaders writes the headers to the key hash, sorted by name
+func writeHeaders(w io.Writer, prefix string, headers http.Header) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			_, _ = io.WriteString(w, prefix+name+": "+value+"\n")
+		}
+	}
+}
+
+// assert that *Client implements gql.Client
+var _ gql.Client = &Client{}
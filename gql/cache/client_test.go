@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/stretchr/testify/assert"
+)
+
+type thingQuery struct {
+	Thing struct {
+		Field int `graphql:"field"`
+	} `graphql:"thing"`
+}
+
+func countingServer(calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		_, _ = fmt.Fprintf(w, `{"data":{"thing":{"field":%d}}}`, n)
+	}))
+}
+
+func TestCachesPerActor(t *testing.T) {
+	var calls int32
+	ts := countingServer(&calls)
+	defer ts.Close()
+
+	userA, userB := uuid.New(), uuid.New()
+	actorA := gql.NewUserActor(&userA, "a@foo.bar")
+	actorA.AllowedRoles = []string{"reader"}
+	store := NewLRU(10)
+	a := New(gql.NewClient(ts.URL, "secret", actorA, "test"), WithStore(store), WithOperationTTL("GetThing", time.Minute))
+	b := New(gql.NewClient(ts.URL, "secret", gql.NewUserActor(&userB, "b@foo.bar"), "test"), WithStore(store), WithOperationTTL("GetThing", time.Minute))
+
+	var q thingQuery
+	assert.NoError(t, a.NamedQuery(context.TODO(), "GetThing", &q, nil))
+	assert.Equal(t, 1, q.Thing.Field)
+	assert.NoError(t, a.NamedQuery(context.TODO(), "GetThing", &q, nil))
+	assert.Equal(t, 1, q.Thing.Field)
+	assert.Equal(t, int32(1), calls)
+
+	assert.NoError(t, b.NamedQuery(context.TODO(), "GetThing", &q, nil))
+	assert.Equal(t, 2, q.Thing.Field, "results must not leak across actors")
+
+	assert.NoError(t, a.NamedQuery(gql.WithHeader(context.TODO(), "x-custom", "foo"), "GetThing", &q, nil))
+	assert.Equal(t, 3, q.Thing.Field, "context headers are part of the key")
+
+	assert.NoError(t, a.NamedQuery(gql.WithCallOptions(context.TODO(), gql.CallRole("reader")), "GetThing", &q, nil))
+	assert.Equal(t, 4, q.Thing.Field, "call roles are part of the key")
+	assert.NoError(t, a.NamedQuery(gql.WithCallOptions(context.TODO(), gql.CallHeader("x-custom", "foo")), "GetThing", &q, nil))
+	assert.Equal(t, 5, q.Thing.Field, "call headers are part of the key")
+
+	tenantActor := gql.NewUserActor(&userA, "a@foo.bar").SetSessionVariable("tenant", "acme")
+	tenantActor.AllowedRoles = actorA.AllowedRoles
+	tenant := New(gql.NewClient(ts.URL, "secret", tenantActor, "test"), WithStore(store), WithOperationTTL("GetThing", time.Minute))
+	assert.NoError(t, tenant.NamedQuery(context.TODO(), "GetThing", &q, nil))
+	assert.Equal(t, 6, q.Thing.Field, "session variables are part of the key")
+
+	// not configured, so not cached
+	assert.NoError(t, a.NamedQuery(context.TODO(), "GetOther", &q, nil))
+	assert.NoError(t, a.NamedQuery(context.TODO(), "GetOther", &q, nil))
+	assert.Equal(t, int32(8), calls)
+}
+
+func TestExpirationAndStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	ts := countingServer(&calls)
+	defer ts.Close()
+
+	now := time.Now()
+	store := NewLRU(10)
+	store.now = func() time.Time { return now }
+	c := New(gql.NewAdminClientFromHost(ts.URL, "secret", "test"), WithStore(store), WithDefaultTTL(time.Minute), WithStaleWhileRevalidate(time.Minute))
+	c.opts.now = store.now
+
+	var q thingQuery
+	assert.NoError(t, c.Query(context.TODO(), &q, nil))
+	assert.Equal(t, 1, q.Thing.Field)
+
+	// stale: served from cache, refreshed in the background
+	now = now.Add(90 * time.Second)
+	assert.NoError(t, c.Query(context.TODO(), &q, nil))
+	assert.Equal(t, 1, q.Thing.Field)
+	assert.Eventually(t, func() bool {
+		entry, ok := store.Get(onlyKey(store))
+		return ok && string(entry.Data) == `{"thing":{"field":2}}`
+	}, time.Second, 5*time.Millisecond)
+
+	// past the stale window of the refreshed entry
+	now = now.Add(3 * time.Minute)
+	assert.NoError(t, c.Query(context.TODO(), &q, nil))
+	assert.Equal(t, 3, q.Thing.Field)
+}
+
+func TestInvalidation(t *testing.T) {
+	var calls int32
+	ts := countingServer(&calls)
+	defer ts.Close()
+
+	c := New(gql.NewAdminClientFromHost(ts.URL, "secret", "test"),
+		WithOperationTTL("GetThing", time.Minute),
+		WithOperationTTL("GetOther", time.Minute),
+		WithOperationTags("GetOther", "things"),
+		WithMutationInvalidates("UpdateThing", OperationTag("GetThing")),
+	)
+
+	var q thingQuery
+	_ = c.NamedQuery(context.TODO(), "GetThing", &q, nil)
+	_ = c.NamedQuery(context.TODO(), "GetOther", &q, nil)
+	assert.Equal(t, 2, c.store.(*LRU).Len())
+
+	assert.NoError(t, c.NamedMutate(context.TODO(), "UpdateThing", &q, nil))
+	assert.Equal(t, 1, c.store.(*LRU).Len())
+
+	c.InvalidateTags("things")
+	assert.Equal(t, 0, c.store.(*LRU).Len())
+
+	_ = c.NamedQuery(context.TODO(), "GetThing", &q, nil)
+	c.Invalidate("GetThing")
+	assert.Equal(t, 0, c.store.(*LRU).Len())
+}
+
+func TestLRUEviction(t *testing.T) {
+	l := NewLRU(2)
+	entry := Entry{StaleUntil: time.Now().Add(time.Minute)}
+	l.Set("a", entry)
+	l.Set("b", entry)
+	_, _ = l.Get("a")
+	l.Set("c", entry)
+
+	_, ok := l.Get("b")
+	assert.False(t, ok, "least recently used entry should be evicted")
+	_, ok = l.Get("a")
+	assert.True(t, ok)
+}
+
+func onlyKey(l *LRU) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k := range l.items {
+		return k
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Entry is a cached query response
+type Entry struct {
+	// Data is the raw json data returned by the query
+	Data []byte
+	// Tags are used to invalidate the entry, they always include the operation name, see OperationTag
+	Tags []string
+	// FreshUntil is the moment the entry expires
+	FreshUntil time.Time
+	// StaleUntil is the moment until which the expired entry can still be served while it's refreshed in the
+	// background. It's equal to FreshUntil when stale-while-revalidate is disabled.
+	StaleUntil time.Time
+}
+
+// Store is the storage backing a cache Client. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the entry stored under key, if any. Stores can return entries past their StaleUntil, the client
+	// will discard them.
+	Get(key string) (Entry, bool)
+	// Set stores the entry under the given key, replacing any previous one
+	Set(key string, entry Entry)
+	// InvalidateTags removes every entry having any of the given tags
+	InvalidateTags(tags ...string)
+}
+
+// LRU is an in-memory Store holding up to a maximum number of entries, evicting the least recently used ones first.
+// Entries are also dropped once they can't be served anymore, even stale.
+type LRU struct {
+	size int
+	now  func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+}
+
+type lruItem struct {
+	key   string
+	entry Entry
+}
+
+// NewLRU creates an in-memory Store holding up to size entries
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:  size,
+		now:   time.Now,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+		tags:  map[string]map[string]struct{}{},
+	}
+}
+
+func (l *LRU) Get(key string) (Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	item := el.Value.(*lruItem)
+	if !l.now().Before(item.entry.StaleUntil) {
+		l.remove(el)
+		return Entry{}, false
+	}
+	l.ll.MoveToFront(el)
+	return item.entry, true
+}
+
+func (l *LRU) Set(key string, entry Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+
+	l.items[key] = l.ll.PushFront(&lruItem{key: key, entry: entry})
+	for _, tag := range entry.Tags {
+		if l.tags[tag] == nil {
+			l.tags[tag] = map[string]struct{}{}
+		}
+		l.tags[tag][key] = struct{}{}
+	}
+
+	for l.size > 0 && l.ll.Len() > l.size {
+		l.remove(l.ll.Back())
+	}
+}
+
+func (l *LRU) InvalidateTags(tags ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range l.tags[tag] {
+			if el, ok := l.items[key]; ok {
+				l.remove(el)
+			}
+		}
+	}
+}
+
+// Len returns the number of entries in the cache
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+// remove must be called holding l.mu
+func (l *LRU) remove(el *list.Element) {
+	item := l.ll.Remove(el).(*lruItem)
+	delete(l.items, item.key)
+	for _, tag := range item.entry.Tags {
+		delete(l.tags[tag], item.key)
+		if len(l.tags[tag]) == 0 {
+			delete(l.tags, tag)
+		}
+	}
+}
+
+// assert that *LRU implements Store
+var _ Store = &LRU{}
@@ -0,0 +1,130 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	XHasuraQueryCacheKey       = "X-Hasura-Query-Cache-Key"
+	XHasuraQueryFamilyCacheKey = "X-Hasura-Query-Family-Cache-Key"
+)
+
+// CacheStatus describes how HGE's query cache handled a request made with WithQueryCaching
+type CacheStatus struct {
+	// Key is the cache key HGE stored the response under, empty if the response wasn't cached
+	Key string
+	// FamilyKey groups the keys of the same query made with different variables
+	FamilyKey string
+	// MaxAge is the remaining time the response will be cached for
+	MaxAge time.Duration
+	// Hit tells whether the response was served from the cache. HGE doesn't tell explicitly, so it's inferred from
+	// the response being younger than the requested ttl.
+	Hit bool
+}
+
+// WithQueryCaching makes every query sent by the client use HGE's query cache (Hasura Cloud/EE), adding the
+// @cached(ttl: N) directive to the query documents. Queries already using the directive are left untouched and
+// mutations are never cached.
+func WithQueryCaching(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.cacheTTL = ttl
+	}
+}
+
+// WithCacheRefresh forces HGE to refresh the cached responses of the queries made with the returned context
+func WithCacheRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey, true)
+}
+
+// WithCacheStatus returns a context that captures how HGE's query cache handled the query made with it. The
+// returned CacheStatus is filled once the query returns.
+func WithCacheStatus(ctx context.Context) (context.Context, *CacheStatus) {
+	status := &CacheStatus{}
+	return context.WithValue(ctx, cacheStatusKey, status), status
+}
+
+type cachedRoundTripper struct {
+	ttl      time.Duration
+	rt       http.RoundTripper
+	requests metric.Int64Counter
+}
+
+func newCachedRoundTripper(ttl time.Duration, rt http.RoundTripper) http.RoundTripper {
+	requests, _ := otel.Meter("github.com/hasura/hge-go-gql-client/gql").Int64Counter(
+		"hasura.query_cache.requests",
+		metric.WithDescription("Queries sent using HGE's query cache, by operation and result (hit or miss)"),
+	)
+	return cachedRoundTripper{ttl: ttl, rt: rt, requests: requests}
+}
+
+func (c cachedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, _, err := readPayload(req)
+	if err != nil || isMutation(p.Query) || strings.Contains(p.Query, "@cached") {
+		return c.rt.RoundTrip(req)
+	}
+
+	ttl := int(c.ttl.Seconds())
+	refresh, _ := req.Context().Value(refreshKey).(bool)
+	directive := fmt.Sprintf("@cached(ttl: %d)", ttl)
+	if refresh {
+		directive = fmt.Sprintf("@cached(ttl: %d, refresh: true)", ttl)
+	}
+	p.Query = addDirective(p.Query, directive)
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	setBody(req, body)
+
+	resp, err := c.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	status := CacheStatus{
+		Key:       resp.Header.Get(XHasuraQueryCacheKey),
+		FamilyKey: resp.Header.Get(XHasuraQueryFamilyCacheKey),
+		MaxAge:    maxAge(resp.Header.Get("Cache-Control")),
+	}
+	status.Hit = !refresh && status.Key != "" && status.MaxAge < c.ttl.Truncate(time.Second)
+
+	if s, ok := req.Context().Value(cacheStatusKey).(*CacheStatus); ok {
+		*s = status
+	}
+
+	result := "miss"
+	if status.Hit {
+		result = "hit"
+	}
+	c.requests.Add(req.Context(), 1, metric.WithAttributes(
+		attribute.String("operation", p.OperationName),
+		attribute.String("result", result),
+	))
+
+	return resp, nil
+}
+
+// maxAge parses the max-age directive of a Cache-Control header
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
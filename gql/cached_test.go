@@ -0,0 +1,61 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddDirective(t *testing.T) {
+	for _, tC := range []struct {
+		document string
+		expected string
+	}{
+		{`{thing{field}}`, `query @cached {thing{field}}`},
+		{`query GetThing{thing{field}}`, `query GetThing @cached {thing{field}}`},
+		{`query GetThing($id:Int!){thing(id: $id){field}}`, `query GetThing($id:Int!) @cached {thing(id: $id){field}}`},
+		{`query ($where:thing_bool_exp!){thing(where: $where){field}}`, `query ($where:thing_bool_exp!) @cached {thing(where: $where){field}}`},
+	} {
+		assert.Equal(t, tC.expected, addDirective(tC.document, "@cached"))
+	}
+}
+
+func TestQueryCaching(t *testing.T) {
+	var documents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		documents = append(documents, p.Query)
+
+		w.Header().Set(XHasuraQueryCacheKey, "some-key")
+		w.Header().Set("Cache-Control", "max-age=42")
+		_, _ = w.Write([]byte(`{"data":{"thing":{"field":1}}}`))
+	}))
+	defer ts.Close()
+
+	cl := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithQueryCaching(time.Minute))
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+
+	ctx, status := WithCacheStatus(context.TODO())
+	assert.NoError(t, cl.NamedQuery(ctx, "GetThing", &query, nil))
+	assert.Equal(t, "query GetThing @cached(ttl: 60) {thing{field}}", documents[0])
+	assert.Equal(t, CacheStatus{Key: "some-key", MaxAge: 42 * time.Second, Hit: true}, *status)
+
+	ctx, status = WithCacheStatus(WithCacheRefresh(context.TODO()))
+	assert.NoError(t, cl.Query(ctx, &query, nil))
+	assert.Equal(t, "query @cached(ttl: 60, refresh: true) {thing{field}}", documents[1])
+	assert.False(t, status.Hit)
+
+	assert.NoError(t, cl.NamedMutate(context.TODO(), "UpdateThing", &query, nil))
+	assert.Equal(t, "mutation UpdateThing{thing{field}}", documents[2])
+}
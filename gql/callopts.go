@@ -0,0 +1,138 @@
+package gql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrRoleNotAllowed is returned for requests made with CallRole when the client's actor can't act with the role
+var ErrRoleNotAllowed = errors.New("role not allowed")
+
+type callOptions struct {
+	timeout       time.Duration
+	role          string
+	operationName string
+	headers       http.Header
+}
+
+// CallOption customizes the requests made with a context returned by WithCallOptions
+type CallOption func(*callOptions)
+
+// CallTimeout overrides the client timeout, set with WithTimeout, for the request
+func CallTimeout(timeout time.Duration) CallOption {
+	return func(opts *callOptions) {
+		opts.timeout = timeout
+	}
+}
+
+// CallRole sends the request with the given role instead of the actor's. Admins can use any role, while other actors
+// can only use roles they're allowed to, other than admin, which takes an elevated client; otherwise the request fails
+// with ErrRoleNotAllowed without being sent.
+func CallRole(role string) CallOption {
+	return func(opts *callOptions) {
+		opts.role = role
+	}
+}
+
+// CallOperationName sends the request with the given operation name, renaming the operation in the document too
+func CallOperationName(name string) CallOption {
+	return func(opts *callOptions) {
+		opts.operationName = name
+	}
+}
+
+// CallHeader sets a header in the request, after the actor and context headers. Like context headers, it must be
+// allowed by the client's HeaderPolicy.
+func CallHeader(name, value string) CallOption {
+	return func(opts *callOptions) {
+		opts.headers.Set(name, value)
+	}
+}
+
+// WithCallOptions returns a context whose requests are customized with the given options, on top of any call options
+// already in ctx.
+//
+//	ctx = gql.WithCallOptions(ctx, gql.CallTimeout(2*time.Minute), gql.CallRole("reader"))
+//	err := client.NamedQuery(ctx, "GetReport", &q, vars)
+func WithCallOptions(ctx context.Context, options ...CallOption) context.Context {
+	opts := getCallOptions(ctx)
+	opts.headers = opts.headers.Clone()
+	if opts.headers == nil {
+		opts.headers = http.Header{}
+	}
+
+	for _, apply := range options {
+		apply(&opts)
+	}
+	return context.WithValue(ctx, callOptionsKey, opts)
+}
+
+// CallHeadersFromContext returns the headers the call options of the context set on the requests: those of CallHeader,
+// and the role of CallRole as x-hasura-role. Like HeadersFromContext, it's meant for middlewares whose behavior
+// depends on the headers, like caches.
+func CallHeadersFromContext(ctx context.Context) http.Header {
+	opts := getCallOptions(ctx)
+	headers := opts.headers.Clone()
+	if opts.role != "" {
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set(XHasuraRole, opts.role)
+	}
+	return headers
+}
+
+func getCallOptions(ctx context.Context) callOptions {
+	opts, _ := ctx.Value(callOptionsKey).(callOptions)
+	return opts
+}
+
+// apply sets the call options on the request, returning the timeout to use for it
+func (opts callOptions) apply(req *http.Request, actor *Actor, policy HeaderPolicy, timeout time.Duration) (time.Duration, error) {
+	if opts.role != "" {
+		if actor == nil || !actor.canSwitchTo(opts.role) {
+			return 0, fmt.Errorf("%w: %s", ErrRoleNotAllowed, opts.role)
+		}
+		req.Header.Set(XHasuraRole, opts.role)
+	}
+
+	if err := policy.check(req.Context(), actor, opts.headers); err != nil {
+		return 0, err
+	}
+	for name, values := range opts.headers {
+		req.Header[name] = values
+	}
+
+	if opts.operationName != "" {
+		p, _, err := readPayload(req)
+		if err != nil {
+			return 0, err
+		}
+		p.OperationName = opts.operationName
+		p.Query = renameOperation(p.Query, opts.operationName)
+		if err := setPayload(req, p); err != nil {
+			return 0, err
+		}
+	}
+
+	if opts.timeout > 0 {
+		timeout = opts.timeout
+	}
+	return timeout, nil
+}
+
+// cancelBody cancels the context of a request once its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,81 @@
+package gql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallOptions(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`).WithLatency(10 * time.Millisecond)
+
+	sampleUUID := uuid.New()
+	admin := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithTimeout(time.Millisecond))
+	user := NewClient(ts.Endpoint(), "admin-secret", NewUserActor(&sampleUUID, "foo@bar.baz"), "test-client")
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+
+	// calls can be given more time than the client timeout, as well as less
+	assert.Error(t, admin.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	ctx := WithCallOptions(context.TODO(), CallTimeout(time.Second))
+	assert.NoError(t, admin.NamedQuery(ctx, "GetThing", &query, nil))
+	ctx = WithCallOptions(context.TODO(), CallTimeout(time.Millisecond))
+	assert.ErrorContains(t, user.NamedQuery(ctx, "GetThing", &query, nil), "deadline exceeded")
+
+	// admins can act with any role, other actors only with theirs
+	ctx = WithCallOptions(ctx, CallTimeout(time.Second), CallRole("reader"), CallHeader("x-request-id", "42"))
+	assert.NoError(t, admin.NamedQuery(ctx, "GetThing", &query, nil))
+	assert.ErrorIs(t, user.NamedQuery(ctx, "GetThing", &query, nil), ErrRoleNotAllowed)
+	assert.NoError(t, user.NamedQuery(WithCallOptions(ctx, CallRole(RoleUser)), "GetThing", &query, nil))
+
+	// even actors allowed to act as admins must be elevated to send admin requests
+	allowedAdmin := NewUserActor(&sampleUUID, "foo@bar.baz")
+	allowedAdmin.AllowedRoles = []string{RoleAdmin}
+	promotable := NewPromotableClient(ts.Endpoint(), "admin-secret", allowedAdmin, "test-client")
+	assert.ErrorIs(t, promotable.NamedQuery(WithCallOptions(ctx, CallRole(RoleAdmin)), "GetThing", &query, nil), ErrRoleNotAllowed)
+
+	// timed out requests might or might not have reached the server
+	var withHeader []gqltest.Request
+	for _, r := range ts.Requests() {
+		if r.Headers.Get("X-Request-Id") == "42" {
+			withHeader = append(withHeader, r)
+		}
+	}
+	if assert.Len(t, withHeader, 2) {
+		assert.Equal(t, "reader", withHeader[0].Role)
+		assert.Equal(t, RoleUser, withHeader[1].Role)
+	}
+
+	ctx = WithCallOptions(context.TODO(), CallOperationName("GetThingForReport"))
+	assert.NoError(t, user.NamedQuery(ctx, "GetThing", &query, nil))
+	assert.NoError(t, user.Query(ctx, &query, nil))
+	renamed := ts.RequestsFor("GetThingForReport")
+	if assert.Len(t, renamed, 2) {
+		assert.Equal(t, "query GetThingForReport{thing{field}}", renamed[0].Query)
+		assert.Equal(t, "query GetThingForReport{thing{field}}", renamed[1].Query)
+	}
+}
+
+func TestRenameOperation(t *testing.T) {
+	for _, tC := range []struct {
+		document string
+		expected string
+	}{
+		{document: "{thing{field}}", expected: "query Renamed{thing{field}}"},
+		{document: "query GetThing{thing{field}}", expected: "query Renamed{thing{field}}"},
+		{document: "query GetThing($id:Int!){thing(id: $id){field}}", expected: "query Renamed($id:Int!){thing(id: $id){field}}"},
+		{document: "mutation UpdateThing @cached {update_thing{affected_rows}}", expected: "mutation Renamed @cached {update_thing{affected_rows}}"},
+		{document: "query @cached {thing{field}}", expected: "query Renamed @cached {thing{field}}"},
+	} {
+		assert.Equal(t, tC.expected, renameOperation(tC.document, "Renamed"))
+	}
+}
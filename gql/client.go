@@ -1,13 +1,18 @@
 package gql
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	gogql "github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/util"
 	untyped "github.com/shahidhk/gql"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
@@ -17,18 +22,32 @@ const (
 	HasuraClientName  = "Hasura-Client-Name"
 	DefaultClientName = "lux-hasura-api"
 
-	XHasuraRole        = "x-hasura-role"
-	XHasuraUserID      = "x-hasura-user-id"
-	XHasuraUserEmail   = "x-hasura-user-email"
-	XHasuraAdminSecret = "X-Hasura-Admin-Secret"
+	XHasuraRole = "x-hasura-role"
+	// XHasuraAllowedRoles lists the default and allowed roles of the actor
+	XHasuraAllowedRoles = "x-hasura-allowed-roles"
+	XHasuraUserID       = "x-hasura-user-id"
+	XHasuraUserEmail    = "x-hasura-user-email"
+	XHasuraAdminSecret  = "X-Hasura-Admin-Secret"
 )
 
 type options struct {
-	timeout time.Duration
+	timeout   time.Duration
+	transport http.RoundTripper
+	dedup     bool
+	cacheTTL  time.Duration
+	persisted bool
+	collector *QueryCollector
+	pool      *EndpointPool
+
+	headerPolicy HeaderPolicy
+
+	auditSink      AuditSink
+	reasonRequired bool
 }
 
 var defaultOptions = options{
-	timeout: 30 * time.Second,
+	timeout:   30 * time.Second,
+	transport: http.DefaultTransport,
 }
 
 type Option func(*options)
@@ -39,15 +58,38 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithRoundTripper replaces http.DefaultTransport as the transport sending the requests to HGE, after the client
+// has set the actor and context headers on them.
+func WithRoundTripper(rt http.RoundTripper) Option {
+	return func(opts *options) {
+		opts.transport = rt
+	}
+}
+
+// WithDeduplication makes identical concurrent queries -same actor, headers, document and variables- share a single
+// http round trip, each caller decoding its own copy of the response. Mutations are never deduplicated.
+func WithDeduplication() Option {
+	return func(opts *options) {
+		opts.dedup = true
+	}
+}
+
 // sudoFunc is a function to return derive a new ActorAwareClient with admin privileges
 // from an existing ActorAwareClient.
 //
 // it's declared as a type because we want to make this function a [strategy pattern](https://wiki.c2.com/?StrategyPattern)
 // some clients will use it to effectively promote themselves to Admin, while others, non-promotable
 // will return an error.
-type sudoFunc func(actor *Actor) (*ActorAwareClient, error)
+//
+// The elevation describes why and where the client is elevated, for the audit trail. It's nil for impersonations in
+// tests, which aren't audited.
+type sudoFunc func(actor *Actor, e *elevation) (*ActorAwareClient, error)
 type untypedFunc func() *untyped.Client
 
+// deriveFunc derives a client with the same configuration acting as the given actor. Unlike sudoFunc, every client
+// can derive others, it's up to the caller to check the actor can be acted as.
+type deriveFunc func(actor *Actor) *ActorAwareClient
+
 // ActorAwareClient is a graphql client which requests are made on behalf of an Actor
 type ActorAwareClient struct {
 	Client
@@ -55,6 +97,8 @@ type ActorAwareClient struct {
 	// A function to derive an admin (or impersonate another user) client from this one. It will return an error if a
 	// client cannot be promoted to an admin, see NewPromotableClient
 	sudoFunc sudoFunc
+	// A function to derive a client acting as another role of the same actor, see WithRole
+	deriveFunc deriveFunc
 	// A function to derive an untyped client from this one. This field is unexported, and production code won't
 	// have any method to get the function or invoke it. Only when the integration build tag is used to compile the
 	// code, the code will compile a method to return the untyped client.
@@ -86,43 +130,136 @@ func NewAdminClient(endpoint, adminSecret, clientName string, options ...Option)
 // and user headers. Think of adminSecret as an API key to access the endpoint, that's why promoting a client to an
 // admin, only means changing the role to admin.
 func NewPromotableClient(endpoint string, adminSecret string, actor *Actor, clientName string, options ...Option) *ActorAwareClient {
-	client := NewClient(endpoint, adminSecret, actor, clientName, options...)
-
-	client.sudoFunc = func(impersonated *Actor) (*ActorAwareClient, error) {
-		return NewPromotableClient(endpoint, adminSecret, impersonated, clientName, options...), nil
-	}
-
-	return client
+	return newClientConfig(endpoint, adminSecret, clientName, options).promotableClient(actor, nil)
 }
 
 // NewClient creates a new client to access the given graphql endpoint as a user, setting the given http headers
 // in the underlying httpClient.
 func NewClient(endpoint string, adminSecret string, actor *Actor, clientName string, options ...Option) *ActorAwareClient {
+	return newClientConfig(endpoint, adminSecret, clientName, options).client(actor, nil)
+}
+
+// clientConfig is shared by a client and the clients derived from it, which only differ in their actor and elevation
+type clientConfig struct {
+	endpoint    string
+	adminSecret string
+	clientName  string
+	opts        options
+	// transport sends the requests of all the clients once their headers are set, see buildTransport
+	transport http.RoundTripper
+}
+
+func newClientConfig(endpoint, adminSecret, clientName string, options []Option) *clientConfig {
 	opts := defaultOptions
 	for _, apply := range options {
 		apply(&opts)
 	}
+	return &clientConfig{
+		endpoint:    endpoint,
+		adminSecret: adminSecret,
+		clientName:  clientName,
+		opts:        opts,
+		transport:   buildTransport(opts),
+	}
+}
 
-	headers := HeadersFor(actor, adminSecret, clientName)
-	httpClient := buildClient(headers)
-	httpClient.Timeout = opts.timeout
+// client creates a client acting as the given actor, elevated with e if not nil
+func (c *clientConfig) client(actor *Actor, e *elevation) *ActorAwareClient {
+	headers := HeadersFor(actor, c.adminSecret, c.clientName)
+	if e != nil {
+		headers[XHasuraElevationID] = e.id
+		if impersonator := e.impersonator(); impersonator != "" {
+			headers[XHasuraImpersonatedBy] = impersonator
+		}
+	}
+	httpClient := buildClient(headers, actor, e, c.opts, c.transport)
 
 	return &ActorAwareClient{
-		Client: gogql.NewClient(endpoint, httpClient),
+		Client: gogql.NewClient(c.endpoint, httpClient),
 		Actor:  actor,
-		sudoFunc: func(actor *Actor) (*ActorAwareClient, error) {
+		sudoFunc: func(actor *Actor, e *elevation) (*ActorAwareClient, error) {
 			return nil, errors.New("by default an actor aware client cannot impersonate another user")
 		},
+		deriveFunc: func(derived *Actor) *ActorAwareClient {
+			return c.client(derived, e)
+		},
 		untypedFunc: func() *untyped.Client {
-			return untyped.NewClient(endpoint, headers)
+			return untyped.NewClient(c.endpoint, headers)
 		},
 	}
 }
 
+// promotableClient creates a client acting as the given actor, elevated with e if not nil, that can be elevated
+func (c *clientConfig) promotableClient(actor *Actor, e *elevation) *ActorAwareClient {
+	client := c.client(actor, e)
+
+	client.sudoFunc = func(impersonated *Actor, next *elevation) (*ActorAwareClient, error) {
+		if next == nil {
+			return c.promotableClient(impersonated, e), nil
+		}
+		if next.reason == "" && c.opts.reasonRequired {
+			return nil, ErrElevationReasonRequired
+		}
+
+		next.id = uuid.NewString()
+		next.actor = actor
+		next.target = impersonated
+		next.sink = c.opts.auditSink
+		next.parent = e
+		elevated := c.promotableClient(impersonated, next)
+		if next.impersonatedBy != "" {
+			next.audit(context.Background(), AuditImpersonation, "")
+		} else {
+			next.audit(context.Background(), AuditElevation, "")
+		}
+		return elevated, nil
+	}
+
+	client.deriveFunc = func(derived *Actor) *ActorAwareClient {
+		return c.promotableClient(derived, e)
+	}
+
+	return client
+}
+
+// WithRole returns a client acting as the same actor with another role, which must be one of the actor's allowed
+// roles unless the actor is an admin. Only admins can switch to the admin role, other actors must be elevated with
+// Elevate so their requests are audited. It returns an error wrapping ErrRoleNotAllowed otherwise.
+func (c *ActorAwareClient) WithRole(role string) (*ActorAwareClient, error) {
+	if !c.Actor.canSwitchTo(role) {
+		return nil, fmt.Errorf("%w: %s", ErrRoleNotAllowed, role)
+	}
+	return c.deriveFunc(c.Actor.WithRole(role)), nil
+}
+
+// NewClientFromAccess creates a new client to access the given graphql endpoint with the session of the given access,
+// including its project lists and any other session variable. It's meant to forward to HGE the session of a request
+// HGE made, like an Action call.
+func NewClientFromAccess(endpoint string, adminSecret string, access *Access, clientName string, options ...Option) *ActorAwareClient {
+	return NewClient(endpoint, adminSecret, sessionActor(access), clientName, options...)
+}
+
+// sessionActor returns an actor whose requests are sent with the whole session of the access, project lists included
+func sessionActor(access *Access) *Actor {
+	actor := &Actor{
+		Role:         access.Role,
+		AllowedRoles: slices.Clone(access.AllowedRoles),
+		UserID:       access.UserID,
+		Email:        access.Email,
+		UsesSAML:     access.UsesSAML,
+	}
+	for name, value := range access.SessionVariables() {
+		if name != util.XHasuraRole && name != util.XHasuraUserID && name != util.XHasuraUserEmail {
+			actor.SetSessionVariable(name, value)
+		}
+	}
+	return actor
+}
+
 // ForceAdmin allows the client to act on behalf of an admin, this function panics if the client cannot
 // be promoted to an Admin client. Prefer AsAdmin instead.
 func (c *ActorAwareClient) ForceAdmin() *ActorAwareClient {
-	admin, err := c.AsAdmin()
+	admin, err := c.elevate("")
 	if err != nil {
 		log.Panicf("Client (role=%s) cannot be promoted to admin. %s", c.Actor.Role, err)
 	}
@@ -130,58 +267,133 @@ func (c *ActorAwareClient) ForceAdmin() *ActorAwareClient {
 }
 
 // AsAdmin allows the client to act on behalf of an admin, this function returns an error in case
-// the client is not promotable
+// the client is not promotable, or requires a reason to be elevated. Prefer Elevate, which records the reason in
+// the audit trail.
 func (c *ActorAwareClient) AsAdmin() (*ActorAwareClient, error) {
-	return c.sudoFunc(c.Actor.AsAdmin())
+	return c.elevate("")
 }
 
 func HeadersFor(actor *Actor, adminSecret, clientName string) map[string]string {
 	headers := make(map[string]string)
 	if actor != nil {
-		var userId string
-		if actor.UserID != nil {
-			userId = actor.UserID.String()
-			headers[XHasuraUserID] = userId
+		// session variables can't set the headers the client sets, like the actor identity, even when the actor
+		// doesn't have a user id or email
+		for name, value := range actor.SessionVariables {
+			if !reservedSessionVariables[strings.ToLower(name)] {
+				headers[name] = value
+			}
 		}
 		headers[XHasuraRole] = actor.Role
-		headers[XHasuraUserEmail] = actor.Email
+		if len(actor.AllowedRoles) > 0 {
+			headers[XHasuraAllowedRoles] = util.StringsToPostgresArray(actor.Roles())
+		}
+		// public actors are anonymous
+		if !actor.IsPublic() {
+			var userId string
+			if actor.UserID != nil {
+				userId = actor.UserID.String()
+				headers[XHasuraUserID] = userId
+			}
+			headers[XHasuraUserEmail] = actor.Email
+		}
 	}
 	headers[HasuraClientName] = clientName
 	headers[XHasuraAdminSecret] = adminSecret
 	return headers
 }
 
-// buildClient creates a new HTTP transport that uses a RoundTripper to set the headers
-// on every request.
+// buildTransport creates the round trippers shared by a client and the clients derived from it, which send the
+// requests once their headers are set.
+func buildTransport(opts options) http.RoundTripper {
+	// round trippers run in the reverse order they're wrapped: documents are rewritten for query caching before
+	// being collected for the allow-list, and collected before being replaced by their hash
+	transport := opts.transport
+	if opts.pool != nil {
+		transport = poolRoundTripper{pool: opts.pool, rt: transport}
+	}
+	if opts.dedup {
+		transport = newDedupRoundTripper(opts.timeout, transport)
+	}
+	if opts.persisted {
+		transport = newPersistedRoundTripper(transport)
+	}
+	if opts.collector != nil {
+		transport = collectorRoundTripper{collector: opts.collector, rt: transport}
+	}
+	if opts.cacheTTL > 0 {
+		transport = newCachedRoundTripper(opts.cacheTTL, transport)
+	}
+	return transport
+}
+
+// buildClient creates a new HTTP client that uses a RoundTripper to set the headers on every request before
+// sending it with the given transport, built by buildTransport.
 // Headers come in two forms:
 // * From the actor information and admin secret provided in ActorAwareClient initialization
 // * Previously set in the context object
-func buildClient(headers map[string]string) *http.Client {
+//
+// Call options set in the context with WithCallOptions are applied last, and can override the client timeout, which
+// is enforced by the RoundTripper instead of the http.Client so calls can be given more time too.
+func buildClient(headers map[string]string, actor *Actor, e *elevation, opts options, transport http.RoundTripper) *http.Client {
 	propagators := otel.GetTextMapPropagator()
+
+	if e != nil {
+		transport = elevationRoundTripper{elevation: e, rt: transport}
+	}
+
 	return &http.Client{
 		Transport: headerRoundTripper{
-			setHeaders: func(req *http.Request) {
+			actor:   actor,
+			timeout: opts.timeout,
+			policy:  opts.headerPolicy,
+			setHeaders: func(req *http.Request) error {
 				// we set the headers the client was configured with
 				for hn, hv := range headers {
 					req.Header.Set(hn, hv)
 				}
-				// and then those that might have been set in the context
-				for hn, hv := range GetHeadersFromContext(req.Context()) {
-					req.Header.Set(hn, hv)
+				// and then those that might have been set in the context, as long as the policy allows them
+				contextHeaders := HeadersFromContext(req.Context())
+				if err := opts.headerPolicy.check(req.Context(), actor, contextHeaders); err != nil {
+					return err
+				}
+				for hn, hv := range contextHeaders {
+					req.Header[hn] = hv
 				}
 				// inject trace headers from context
 				propagators.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+				return nil
 			},
-			rt: http.DefaultTransport},
+			rt: transport},
 	}
 }
 
 type headerRoundTripper struct {
-	setHeaders func(req *http.Request)
+	actor      *Actor
+	timeout    time.Duration
+	policy     HeaderPolicy
+	setHeaders func(req *http.Request) error
 	rt         http.RoundTripper
 }
 
 func (h headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	h.setHeaders(req)
-	return h.rt.RoundTrip(req)
+	if err := h.setHeaders(req); err != nil {
+		return nil, err
+	}
+	timeout, err := getCallOptions(req.Context()).apply(req, h.actor, h.policy, h.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return h.rt.RoundTrip(req)
+	}
+
+	// the deadline covers reading the response body too, so the context is canceled once it's closed
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := h.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
 }
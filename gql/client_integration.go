@@ -9,7 +9,7 @@ import (
 
 // As allows to impersonate a certain actor in tests
 func (c *ActorAwareClient) As(actor *Actor) *ActorAwareClient {
-	client, err := c.sudoFunc(actor)
+	client, err := c.sudoFunc(actor, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -4,20 +4,20 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestHasuraHeaders(t *testing.T) {
-	var h http.Header
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h = r.Header
-	}))
-	defer ts.Close()
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
 
 	sampleUUID := uuid.New()
 
@@ -64,7 +64,9 @@ func TestHasuraHeaders(t *testing.T) {
 		},
 	} {
 		t.Run(tC.desc, func(t *testing.T) {
-			_ = tC.cl.Query(context.TODO(), &query, nil)
+			assert.NoError(t, tC.cl.Query(context.TODO(), &query, nil))
+			requests := ts.Requests()
+			h := requests[len(requests)-1].Headers
 			for hn, hv := range tC.expectedHeaders {
 				assert.Equal(t, hv, h.Get(hn))
 
@@ -74,10 +76,8 @@ func TestHasuraHeaders(t *testing.T) {
 }
 
 func TestTimeout(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(10 * time.Millisecond)
-	}))
-	defer ts.Close()
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").WithLatency(10 * time.Millisecond)
 
 	cl := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithTimeout(1*time.Millisecond))
 
@@ -100,11 +100,8 @@ func TestTimeout(t *testing.T) {
 }
 
 func TestContextHeaders(t *testing.T) {
-	var h http.Header
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h = r.Header
-	}))
-	defer ts.Close()
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
 
 	cl := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client")
 
@@ -121,9 +118,98 @@ func TestContextHeaders(t *testing.T) {
 		"extra-header-2": "baz",
 	})
 	ctx = WithHeader(ctx, "extra-header-1", "reset")
-	cl.Query(ctx, &query, nil)
+	assert.NoError(t, cl.Query(ctx, &query, nil))
+
+	h := ts.Requests()[0].Headers
 
 	assert.Equal(t, h.Get("single-header"), "foo")
 	assert.Equal(t, h.Get("extra-header-1"), "reset")
 	assert.Equal(t, h.Get("extra-header-2"), "baz")
 }
+
+func TestDeduplication(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"data":{"thing":{"field":1}}}`))
+	}))
+	defer ts.Close()
+
+	sampleUUID := uuid.New()
+	admin := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithDeduplication())
+	user := NewClient(ts.URL+"/v1/graphql", "admin-secret", NewUserActor(&sampleUUID, "foo@bar.baz"), "test-client", WithDeduplication())
+
+	type query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+
+	run := func(n int, do func(i int, q *query) error) []query {
+		results := make([]query, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, do(i, &results[i]))
+			}(i)
+		}
+		wg.Wait()
+		return results
+	}
+
+	results := run(10, func(_ int, q *query) error {
+		return admin.NamedQuery(context.TODO(), "GetThing", q, nil)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, r := range results {
+		assert.Equal(t, 1, r.Thing.Field)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	run(2, func(i int, q *query) error {
+		if i == 0 {
+			return admin.NamedQuery(context.TODO(), "GetThing", q, nil)
+		}
+		return user.NamedQuery(context.TODO(), "GetThing", q, nil)
+	})
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "different actors should not share responses")
+
+	atomic.StoreInt32(&calls, 0)
+	run(5, func(_ int, q *query) error {
+		return admin.NamedMutate(context.TODO(), "UpdateThing", q, nil)
+	})
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls), "mutations should never be deduplicated")
+
+	// the first caller timing out doesn't fail the callers sharing its round trip
+	atomic.StoreInt32(&calls, 0)
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.TODO()
+			if i == 0 {
+				ctx = WithCallOptions(ctx, CallTimeout(10*time.Millisecond))
+			} else {
+				time.Sleep(5 * time.Millisecond)
+			}
+			var q query
+			errs[i] = admin.NamedQuery(ctx, "GetThing", &q, nil)
+		}(i)
+	}
+	wg.Wait()
+	assert.ErrorIs(t, errs[0], context.DeadlineExceeded)
+	assert.NoError(t, errs[1])
+	assert.NoError(t, errs[2])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// while a longer CallTimeout gives the shared round trip more time than the client timeout
+	short := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithDeduplication(), WithTimeout(10*time.Millisecond))
+	var q query
+	assert.NoError(t, short.NamedQuery(WithCallOptions(context.TODO(), CallTimeout(time.Second)), "GetThing", &q, nil))
+	assert.Equal(t, 1, q.Thing.Field)
+}
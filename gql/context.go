@@ -2,31 +2,119 @@ package gql
 
 import (
 	"context"
+	"net/http"
+	"sync"
 )
 
 type key int
 
-const headerKey key = iota
+const (
+	headerKey key = iota
+	refreshKey
+	cacheStatusKey
+	callOptionsKey
+	actorKey
+	providerKey
+)
+
+// headerLayer is a change to the headers of the parent context. Layers are never modified once in a context, so
+// child contexts can't affect their parents, and contexts can be shared between goroutines.
+type headerLayer struct {
+	parent *headerLayer
+	// set replaces the values of the headers, and add appends to them. A header set to nil values is removed.
+	set map[string][]string
+	add map[string][]string
+	// names are the names the headers were given, by canonical name, for GetHeadersFromContext
+	names map[string]string
+
+	once    sync.Once
+	headers http.Header
+}
+
+// resolved returns the headers of the layer applied on top of its parents. The returned header must not be modified.
+func (l *headerLayer) resolved() http.Header {
+	if l == nil {
+		return http.Header{}
+	}
+	l.once.Do(func() {
+		headers := l.parent.resolved().Clone()
+		for name, values := range l.set {
+			if values == nil {
+				delete(headers, name)
+			} else {
+				headers[name] = values
+			}
+		}
+		for name, values := range l.add {
+			headers[name] = append(headers[name], values...)
+		}
+		l.headers = headers
+	})
+	return l.headers
+}
+
+// name returns the name the header was last given in the layer or its parents, or its canonical name
+func (l *headerLayer) name(canonical string) string {
+	for ; l != nil; l = l.parent {
+		if name, ok := l.names[canonical]; ok {
+			return name
+		}
+	}
+	return canonical
+}
+
+func withLayer(ctx context.Context, layer *headerLayer) context.Context {
+	layer.parent, _ = ctx.Value(headerKey).(*headerLayer)
+	return context.WithValue(ctx, headerKey, layer)
+}
 
+// WithHeader returns a context whose requests are sent with the given header, replacing any value set by the parent
+// context. Header names are case-insensitive.
 func WithHeader(ctx context.Context, key, value string) context.Context {
 	return WithHeaders(ctx, map[string]string{key: value})
 }
 
+// WithHeaders returns a context whose requests are sent with the given headers, replacing any value set by the parent
+// context. Header names are case-insensitive.
 func WithHeaders(ctx context.Context, hs map[string]string) context.Context {
-	headers := GetHeadersFromContext(ctx)
+	set := make(map[string][]string, len(hs))
+	names := make(map[string]string, len(hs))
 	for k, v := range hs {
-		headers[k] = v
+		set[http.CanonicalHeaderKey(k)] = []string{v}
+		names[http.CanonicalHeaderKey(k)] = k
 	}
-	return context.WithValue(ctx, headerKey, headers)
+	return withLayer(ctx, &headerLayer{set: set, names: names})
+}
+
+// AddHeader returns a context whose requests are sent with the given value added to those the parent context set for
+// the header.
+func AddHeader(ctx context.Context, key, value string) context.Context {
+	canonical := http.CanonicalHeaderKey(key)
+	return withLayer(ctx, &headerLayer{add: map[string][]string{canonical: {value}}, names: map[string]string{canonical: key}})
+}
+
+// WithoutHeader returns a context whose requests are sent without the header set by the parent context. Headers set
+// by the client, such as the actor headers, aren't affected.
+func WithoutHeader(ctx context.Context, key string) context.Context {
+	return withLayer(ctx, &headerLayer{set: map[string][]string{http.CanonicalHeaderKey(key): nil}})
+}
+
+// HeadersFromContext returns a copy of the headers set in the context, with canonical names
+func HeadersFromContext(ctx context.Context) http.Header {
+	layer, _ := ctx.Value(headerKey).(*headerLayer)
+	return layer.resolved().Clone()
 }
 
+// GetHeadersFromContext returns the first value of each header set in the context, keyed by the name it was last
+// set with, as names are case-insensitive. Prefer HeadersFromContext, which keeps every value of multi-valued headers
+// and can be looked up with any case.
 func GetHeadersFromContext(ctx context.Context) map[string]string {
-	h := ctx.Value(headerKey)
-	var headers map[string]string
-	if h == nil {
-		headers = map[string]string{}
-	} else {
-		headers = h.(map[string]string)
+	layer, _ := ctx.Value(headerKey).(*headerLayer)
+	headers := map[string]string{}
+	for name, values := range layer.resolved() {
+		if len(values) > 0 {
+			headers[layer.name(name)] = values[0]
+		}
 	}
 	return headers
 }
@@ -0,0 +1,53 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderContext(t *testing.T) {
+	parent := WithHeaders(context.Background(), map[string]string{"x-tenant": "foo", "X-Trace": "1"})
+	child := WithHeader(parent, "X-TENANT", "bar")
+	child = AddHeader(child, "x-trace", "2")
+	child = WithoutHeader(child, "x-missing")
+
+	assert.Equal(t, http.Header{"X-Tenant": {"foo"}, "X-Trace": {"1"}}, HeadersFromContext(parent))
+	assert.Equal(t, http.Header{"X-Tenant": {"bar"}, "X-Trace": {"1", "2"}}, HeadersFromContext(child))
+	// keyed by the names headers were given, as they were before headers were case-insensitive
+	assert.Equal(t, map[string]string{"x-tenant": "foo", "X-Trace": "1"}, GetHeadersFromContext(parent))
+	assert.Equal(t, map[string]string{"X-TENANT": "bar", "x-trace": "1"}, GetHeadersFromContext(child))
+
+	removed := WithoutHeader(child, "X-Trace")
+	assert.Equal(t, http.Header{"X-Tenant": {"bar"}}, HeadersFromContext(removed))
+	assert.Equal(t, http.Header{"X-Trace": {"3"}}, HeadersFromContext(AddHeader(WithoutHeader(removed, "x-tenant"), "x-trace", "3")))
+
+	// the returned headers are copies
+	HeadersFromContext(child).Add("X-Trace", "4")
+	assert.Equal(t, []string{"1", "2"}, HeadersFromContext(child).Values("X-Trace"))
+	assert.Empty(t, HeadersFromContext(context.Background()))
+}
+
+func TestHeaderContextConcurrency(t *testing.T) {
+	parent := WithHeader(context.Background(), "x-shared", "parent")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprint(i)
+			child := WithHeader(parent, "x-shared", value)
+			child = AddHeader(child, "x-multi", value)
+			assert.Equal(t, value, HeadersFromContext(child).Get("x-shared"))
+			assert.Equal(t, []string{value}, HeadersFromContext(child).Values("x-multi"))
+			assert.Equal(t, "parent", GetHeadersFromContext(parent)["x-shared"])
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, http.Header{"X-Shared": {"parent"}}, HeadersFromContext(parent))
+}
@@ -0,0 +1,252 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hasura/hge-go-gql-client/gql/boolexpr"
+)
+
+// ErrNotFound is returned by Load when the batch function did not return a value for the requested key
+var ErrNotFound = errors.New("dataloader: key not found in batch result")
+
+// BatchFunc resolves all the given keys in a single round trip. Keys missing from the returned map are reported as
+// ErrNotFound to their callers.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+type options struct {
+	wait     time.Duration
+	maxBatch int
+}
+
+var defaultOptions = options{
+	wait:     2 * time.Millisecond,
+	maxBatch: 100,
+}
+
+type Option func(*options)
+
+// WithWait sets how long a batch is kept open waiting for more keys before it's dispatched
+func WithWait(wait time.Duration) Option {
+	return func(opts *options) {
+		opts.wait = wait
+	}
+}
+
+// WithMaxBatch sets the maximum number of keys in a batch. A batch reaching this size is dispatched right away.
+func WithMaxBatch(maxBatch int) Option {
+	return func(opts *options) {
+		opts.maxBatch = maxBatch
+	}
+}
+
+type result[V any] struct {
+	value V
+	err   error
+	done  chan struct{}
+}
+
+type batch[K comparable, V any] struct {
+	ctx     context.Context
+	keys    []K
+	results map[K]*result[V]
+	timer   *time.Timer
+}
+
+// Loader collects the keys requested by concurrent calls to Load within a time/size window, resolves them with a
+// single call to its BatchFunc, and fans the results back out to each caller. Resolved values are cached until
+// cleared, so a Loader is meant to live as long as a single request, see WithScope.
+type Loader[K comparable, V any] struct {
+	fetch BatchFunc[K, V]
+	opts  options
+
+	mu      sync.Mutex
+	cache   map[K]*result[V]
+	pending *batch[K, V]
+}
+
+// New creates a Loader resolving keys with the given BatchFunc
+func New[K comparable, V any](fetch BatchFunc[K, V], options ...Option) *Loader[K, V] {
+	opts := defaultOptions
+	for _, apply := range options {
+		apply(&opts)
+	}
+
+	return &Loader[K, V]{
+		fetch: fetch,
+		opts:  opts,
+		cache: map[K]*result[V]{},
+	}
+}
+
+// Load returns the value for the given key, joining the batch currently being collected or reusing a previously
+// loaded value.
+//
+// The batch is resolved with the context of the first caller that joined it, stripped of its cancellation so one
+// caller giving up doesn't fail the batch for everybody else; ctx only bounds how long this caller waits.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	res, ok := l.cache[key]
+	if !ok {
+		res = l.enqueue(ctx, key)
+		l.cache[key] = res
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-res.done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// LoadMany loads all the given keys, returning their values in the same order. It fails with the first error found.
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, error) {
+	type loaded struct {
+		value V
+		err   error
+	}
+
+	results := make([]loaded, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key K) {
+			defer wg.Done()
+			value, err := l.Load(ctx, key)
+			results[i] = loaded{value, err}
+		}(i, key)
+	}
+	wg.Wait()
+
+	values := make([]V, len(keys))
+	for i, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+		values[i] = r.value
+	}
+	return values, nil
+}
+
+// Prime stores the given value in the cache, unless the key was already loaded or is being loaded
+func (l *Loader[K, V]) Prime(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.cache[key]; ok {
+		return
+	}
+	res := &result[V]{value: value, done: make(chan struct{})}
+	close(res.done)
+	l.cache[key] = res
+}
+
+// Clear removes the given key from the cache, so the next Load fetches it again. Typically used after a mutation
+// changing the entity.
+func (l *Loader[K, V]) Clear(key K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.cache, key)
+}
+
+// ClearAll empties the cache
+func (l *Loader[K, V]) ClearAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cache = map[K]*result[V]{}
+}
+
+// enqueue adds the key to the pending batch, creating it if needed, and returns its result. It must be called holding
+// l.mu.
+func (l *Loader[K, V]) enqueue(ctx context.Context, key K) *result[V] {
+	if l.pending == nil {
+		b := &batch[K, V]{
+			ctx:     context.WithoutCancel(ctx),
+			results: map[K]*result[V]{},
+		}
+		b.timer = time.AfterFunc(l.opts.wait, func() {
+			l.mu.Lock()
+			if l.pending != b {
+				// already dispatched because it was full
+				l.mu.Unlock()
+				return
+			}
+			l.pending = nil
+			l.mu.Unlock()
+			l.dispatch(b)
+		})
+		l.pending = b
+	}
+
+	b := l.pending
+	// the key might have been cleared while its batch is pending: its callers share the same result
+	if res, ok := b.results[key]; ok {
+		return res
+	}
+	res := &result[V]{done: make(chan struct{})}
+	b.keys = append(b.keys, key)
+	b.results[key] = res
+
+	if l.opts.maxBatch > 0 && len(b.keys) >= l.opts.maxBatch {
+		b.timer.Stop()
+		l.pending = nil
+		go l.dispatch(b)
+	}
+	return res
+}
+
+func (l *Loader[K, V]) dispatch(b *batch[K, V]) {
+	values, err := l.fetch(b.ctx, b.keys)
+
+	l.mu.Lock()
+	for key, res := range b.results {
+		switch value, ok := values[key]; {
+		case err != nil:
+			res.err = err
+		case !ok:
+			res.err = ErrNotFound
+		default:
+			res.value = value
+		}
+		// failed loads are not cached, so they can be retried
+		if res.err != nil && l.cache[key] == res {
+			delete(l.cache, key)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, res := range b.results {
+		close(res.done)
+	}
+}
+
+// WhereIn builds a BatchFunc that resolves a whole batch with a single query, filtering the given column with an
+// _in expression over the batched keys. fetch receives the where clause to use as the query's bool_exp variable, and
+// keyOf tells which key each returned row belongs to.
+//
+//	users := dataloader.New(dataloader.WhereIn("id", func(ctx context.Context, where map[string]any) ([]User, error) {
+//		var q struct {
+//			Users []User `graphql:"users(where: $where)"`
+//		}
+//		err := client.NamedQuery(ctx, "GetUsersByID", &q, map[string]any{"where": users_bool_exp(where)})
+//		return q.Users, err
+//	}, func(u User) uuid.UUID { return u.ID }))
+func WhereIn[K comparable, V any](column string, fetch func(ctx context.Context, where map[string]any) ([]V, error), keyOf func(V) K) BatchFunc[K, V] {
+	return func(ctx context.Context, keys []K) (map[K]V, error) {
+		rows, err := fetch(ctx, map[string]any{column: boolexpr.In(keys)})
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[K]V, len(rows))
+		for _, row := range rows {
+			values[keyOf(row)] = row
+		}
+		return values, nil
+	}
+}
@@ -0,0 +1,142 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func countingBatch(calls *int32, batches *[][]int, mu *sync.Mutex) BatchFunc[int, string] {
+	return func(ctx context.Context, keys []int) (map[int]string, error) {
+		atomic.AddInt32(calls, 1)
+		mu.Lock()
+		*batches = append(*batches, keys)
+		mu.Unlock()
+
+		values := map[int]string{}
+		for _, k := range keys {
+			if k >= 0 {
+				values[k] = string(rune('a' + k))
+			}
+		}
+		return values, nil
+	}
+}
+
+func TestLoaderBatchesConcurrentLoads(t *testing.T) {
+	var calls int32
+	var batches [][]int
+	var mu sync.Mutex
+	l := New(countingBatch(&calls, &batches, &mu), WithWait(10*time.Millisecond))
+
+	values, err := l.LoadMany(context.TODO(), []int{0, 1, 2, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "b"}, values)
+	assert.Equal(t, int32(1), calls)
+	assert.ElementsMatch(t, []int{0, 1, 2}, batches[0])
+
+	// cached
+	v, err := l.Load(context.TODO(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", v)
+	assert.Equal(t, int32(1), calls)
+
+	l.Clear(2)
+	_, _ = l.Load(context.TODO(), 2)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestLoaderClearWhilePending(t *testing.T) {
+	var calls int32
+	var batches [][]int
+	var mu sync.Mutex
+	l := New(countingBatch(&calls, &batches, &mu), WithWait(20*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, errs[0] = l.Load(ctx, 1)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	l.ClearAll()
+	_, errs[1] = l.Load(ctx, 1)
+	wg.Wait()
+
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, [][]int{{1}}, batches)
+}
+
+func TestLoaderMaxBatch(t *testing.T) {
+	var calls int32
+	var batches [][]int
+	var mu sync.Mutex
+	l := New(countingBatch(&calls, &batches, &mu), WithWait(10*time.Millisecond), WithMaxBatch(2))
+
+	_, err := l.LoadMany(context.TODO(), []int{0, 1, 2, 3, 4})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls)
+	for _, b := range batches {
+		assert.LessOrEqual(t, len(b), 2)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	var calls int32
+	var batches [][]int
+	var mu sync.Mutex
+	l := New(countingBatch(&calls, &batches, &mu))
+
+	_, err := l.Load(context.TODO(), -1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	boom := errors.New("boom")
+	failing := New(func(ctx context.Context, keys []int) (map[int]string, error) {
+		return nil, boom
+	})
+	_, err = failing.Load(context.TODO(), 1)
+	assert.ErrorIs(t, err, boom)
+	assert.Empty(t, failing.cache, "failed loads should not be cached")
+}
+
+func TestScope(t *testing.T) {
+	newLoader := func() *Loader[int, string] {
+		return New(func(ctx context.Context, keys []int) (map[int]string, error) {
+			return map[int]string{}, nil
+		})
+	}
+
+	ctx := WithScope(context.TODO())
+	assert.Same(t, For(ctx, "users", newLoader), For(ctx, "users", newLoader))
+	assert.NotSame(t, For(ctx, "users", newLoader), For(WithScope(ctx), "users", newLoader))
+	assert.NotSame(t, For(context.TODO(), "users", newLoader), For(context.TODO(), "users", newLoader))
+}
+
+func TestWhereIn(t *testing.T) {
+	type user struct {
+		ID   int
+		Name string
+	}
+
+	var where map[string]any
+	l := New(WhereIn("id", func(ctx context.Context, w map[string]any) ([]user, error) {
+		where = w
+		return []user{{1, "foo"}, {2, "bar"}}, nil
+	}, func(u user) int { return u.ID }), WithWait(10*time.Millisecond))
+
+	users, err := l.LoadMany(context.TODO(), []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []user{{1, "foo"}, {2, "bar"}}, users)
+	assert.ElementsMatch(t, []int{1, 2}, where["id"].(map[string]any)["_in"])
+}
@@ -0,0 +1,41 @@
+package dataloader
+
+import (
+	"context"
+	"sync"
+)
+
+type key int
+
+const scopeKey key = iota
+
+type scope struct {
+	mu      sync.Mutex
+	loaders map[string]any
+}
+
+// WithScope returns a context holding a fresh set of loaders. It's meant to be called once per incoming request, so
+// loads are batched and cached within the request, but never shared across requests (and hence actors).
+func WithScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey, &scope{loaders: map[string]any{}})
+}
+
+// For returns the loader registered under the given name in the context's scope, creating it with newLoader the
+// first time. When the context has no scope, a new loader is returned on every call, so loads still work but are
+// neither batched nor cached.
+func For[K comparable, V any](ctx context.Context, name string, newLoader func() *Loader[K, V]) *Loader[K, V] {
+	s, ok := ctx.Value(scopeKey).(*scope)
+	if !ok {
+		return newLoader()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.loaders[name].(*Loader[K, V]); ok {
+		return l
+	}
+	l := newLoader()
+	s.loaders[name] = l
+	return l
+}
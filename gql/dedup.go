@@ -0,0 +1,123 @@
+package gql
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// perCallHeaders are set on every request with different values, so they're ignored when deciding whether two
+// requests are identical.
+var perCallHeaders = map[string]bool{
+	"Traceparent": true,
+	"Tracestate":  true,
+	"Baggage":     true,
+}
+
+// inflight is shared by all the clients, so identical queries are deduplicated even when they're sent by different
+// client instances for the same actor, as it happens with per-request clients.
+var inflight singleflight.Group
+
+// dedupRoundTripper shares one round trip among identical concurrent queries. Two requests are identical when they
+// have the same url, headers (and hence actor) and body (document and variables). Mutations are always sent.
+//
+// The shared round trip runs with the values of the first request's context, but not its cancellation: it's bounded
+// by the client timeout, or the first caller's CallTimeout when longer, so a caller giving up, or using a shorter
+// CallTimeout, doesn't fail the others. Each caller stops waiting when its own context is done.
+type dedupRoundTripper struct {
+	timeout time.Duration
+	rt      http.RoundTripper
+}
+
+type sharedResponse struct {
+	resp *http.Response
+	body []byte
+}
+
+func newDedupRoundTripper(timeout time.Duration, rt http.RoundTripper) http.RoundTripper {
+	return dedupRoundTripper{timeout: timeout, rt: rt}
+}
+
+func (d dedupRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, body, err := readPayload(req)
+	if err != nil || requestIsMutation(req, p) {
+		return d.rt.RoundTrip(req)
+	}
+
+	ch := inflight.DoChan(dedupKey(req, body), func() (any, error) {
+		ctx := context.WithoutCancel(req.Context())
+		if timeout := d.timeoutFor(req); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		resp, err := d.rt.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return sharedResponse{resp: resp, body: respBody}, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(sharedResponse).copyFor(req), nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// timeoutFor returns the timeout of the round trip shared with the given request: the client timeout, unless the
+// request was given more time with CallTimeout
+func (d dedupRoundTripper) timeoutFor(req *http.Request) time.Duration {
+	if call := getCallOptions(req.Context()).timeout; d.timeout > 0 && call > d.timeout {
+		return call
+	}
+	return d.timeout
+}
+
+// copyFor returns a response that can be consumed independently of the copies given to other callers
+func (s sharedResponse) copyFor(req *http.Request) *http.Response {
+	resp := *s.resp
+	resp.Header = s.resp.Header.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(s.body))
+	resp.ContentLength = int64(len(s.body))
+	resp.Request = req
+	return &resp
+}
+
+func dedupKey(req *http.Request, body []byte) string {
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if !perCallHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	_, _ = io.WriteString(h, req.Method+" "+req.URL.String()+"\n")
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			_, _ = io.WriteString(h, name+": "+value+"\n")
+		}
+	}
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
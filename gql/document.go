@@ -0,0 +1,127 @@
+package gql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// payload is the body of a graphql request over http, as sent by go-graphql-client
+type payload struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+}
+
+// readBody reads the whole request body and replaces it with a fresh reader over the same bytes, so the request can
+// still be sent afterwards.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	setBody(req, body)
+	return body, nil
+}
+
+// setBody replaces the request body with the given bytes
+func setBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// readPayload parses the graphql payload of the given request, leaving the body untouched for it to be sent. The
+// raw body is returned too.
+func readPayload(req *http.Request) (payload, []byte, error) {
+	var p payload
+	body, err := readBody(req)
+	if err != nil {
+		return p, nil, err
+	}
+	err = json.Unmarshal(body, &p)
+	return p, body, err
+}
+
+// setPayload replaces the request body with the given payload
+func setPayload(req *http.Request, p payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	setBody(req, body)
+	return nil
+}
+
+// isMutation tells whether the given graphql document is a mutation. go-graphql-client always renders mutations
+// with the explicit mutation keyword, while queries might use the shorthand form.
+func isMutation(document string) bool {
+	return strings.HasPrefix(strings.TrimSpace(document), "mutation")
+}
+
+type mutationContextKey struct{}
+
+// markMutation records in the request context whether it sends a mutation, so the round trippers that only see the
+// document once it's been replaced by its hash still know its operation type.
+func markMutation(req *http.Request, document string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), mutationContextKey{}, isMutation(document)))
+}
+
+// requestIsMutation tells whether the request sends a mutation, as recorded by markMutation or else from its payload
+func requestIsMutation(req *http.Request, p payload) bool {
+	if mutation, ok := req.Context().Value(mutationContextKey{}).(bool); ok {
+		return mutation
+	}
+	return isMutation(p.Query)
+}
+
+// addDirective adds the given operation directive to the document, right before its selection set. Documents using
+// the query shorthand form ({ ... }) are turned into anonymous queries, as directives need the operation keyword.
+func addDirective(document, directive string) string {
+	depth := 0
+	for i, r := range document {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '{':
+			if depth > 0 {
+				continue
+			}
+			head := strings.TrimSpace(document[:i])
+			if head == "" {
+				head = "query"
+			}
+			return head + " " + directive + " " + document[i:]
+		}
+	}
+	return document
+}
+
+// renameOperation sets the name of the operation in the document, keeping its type. Documents using the query
+// shorthand form become named queries.
+func renameOperation(document, name string) string {
+	i := strings.IndexAny(document, "({@")
+	if i < 0 {
+		return document
+	}
+	head := document[:i]
+	kind := "query"
+	if fields := strings.Fields(head); len(fields) > 0 {
+		kind = fields[0]
+	}
+	// the space before a directive is kept
+	separator := head[len(strings.TrimRightFunc(head, unicode.IsSpace)):]
+	return kind + " " + name + separator + document[i:]
+}
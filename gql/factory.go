@@ -0,0 +1,135 @@
+package gql
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+type transportOptions struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	dialTimeout         time.Duration
+	keepAlive           time.Duration
+	tlsHandshakeTimeout time.Duration
+}
+
+var defaultTransportOptions = transportOptions{
+	maxIdleConns:        100,
+	maxIdleConnsPerHost: 100,
+	idleConnTimeout:     90 * time.Second,
+	dialTimeout:         5 * time.Second,
+	keepAlive:           30 * time.Second,
+	tlsHandshakeTimeout: 5 * time.Second,
+}
+
+type TransportOption func(*transportOptions)
+
+// WithMaxIdleConns sets how many idle connections are kept open, in total and per host, 100 of each by default.
+// http.DefaultTransport only keeps 2 per host, so concurrent requests to HGE keep opening new connections.
+func WithMaxIdleConns(total, perHost int) TransportOption {
+	return func(opts *transportOptions) {
+		opts.maxIdleConns = total
+		opts.maxIdleConnsPerHost = perHost
+	}
+}
+
+// WithIdleConnTimeout sets for how long idle connections are kept open, 90 seconds by default
+func WithIdleConnTimeout(timeout time.Duration) TransportOption {
+	return func(opts *transportOptions) {
+		opts.idleConnTimeout = timeout
+	}
+}
+
+// WithDialTimeout sets how long opening a connection, and its TLS handshake, can take, 5 seconds each by default
+func WithDialTimeout(dial, tlsHandshake time.Duration) TransportOption {
+	return func(opts *transportOptions) {
+		opts.dialTimeout = dial
+		opts.tlsHandshakeTimeout = tlsHandshake
+	}
+}
+
+// WithKeepAlive sets the interval of the TCP keep-alive probes of the connections, 30 seconds by default
+func WithKeepAlive(interval time.Duration) TransportOption {
+	return func(opts *transportOptions) {
+		opts.keepAlive = interval
+	}
+}
+
+// NewTransport creates a transport tuned to send many concurrent requests to HGE over a few long-lived connections,
+// using HTTP/2 when the endpoint supports it.
+func NewTransport(options ...TransportOption) *http.Transport {
+	opts := defaultTransportOptions
+	for _, apply := range options {
+		apply(&opts)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   opts.dialTimeout,
+		KeepAlive: opts.keepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          opts.maxIdleConns,
+		MaxIdleConnsPerHost:   opts.maxIdleConnsPerHost,
+		IdleConnTimeout:       opts.idleConnTimeout,
+		TLSHandshakeTimeout:   opts.tlsHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// ClientFactory creates clients for the same endpoint, admin secret and options acting as different actors. All of
+// them, and the clients derived from them with AsAdmin, Elevate, Impersonate or WithRole, send their requests over
+// the factory's transport, so creating a client per request doesn't open new connections. The round trippers of the
+// options are built once too, only the headers are set up for each client.
+//
+//	factory := gql.NewClientFactory(endpoint, adminSecret, "my-api", nil, gql.WithTimeout(10*time.Second))
+//	defer factory.Close()
+//	client := factory.Client(actor)
+type ClientFactory struct {
+	config    *clientConfig
+	transport *http.Transport
+}
+
+// NewClientFactory creates a factory of clients sharing the given transport, or one created with NewTransport when
+// nil. The options apply to every client, except WithRoundTripper: the clients always use the factory's transport.
+func NewClientFactory(endpoint, adminSecret, clientName string, transport *http.Transport, options ...Option) *ClientFactory {
+	if transport == nil {
+		transport = NewTransport()
+	}
+	return &ClientFactory{
+		config:    newClientConfig(endpoint, adminSecret, clientName, append(options[:len(options):len(options)], WithRoundTripper(transport))),
+		transport: transport,
+	}
+}
+
+// Client creates a client acting as the given actor, see NewClient
+func (f *ClientFactory) Client(actor *Actor) *ActorAwareClient {
+	return f.config.client(actor, nil)
+}
+
+// PromotableClient creates a client acting as the given actor that can be elevated, see NewPromotableClient
+func (f *ClientFactory) PromotableClient(actor *Actor) *ActorAwareClient {
+	return f.config.promotableClient(actor, nil)
+}
+
+// AdminClient creates a client acting as an admin, see NewAdminClient
+func (f *ClientFactory) AdminClient() *ActorAwareClient {
+	return f.PromotableClient(NewAdminActor())
+}
+
+// ClientProvider creates a provider caching the clients of up to maxClients actors, created by the factory
+func (f *ClientFactory) ClientProvider(maxClients int) *ClientProvider {
+	provider := NewClientProvider(f.config.endpoint, f.config.adminSecret, f.config.clientName, maxClients)
+	provider.newClient = f.Client
+	return provider
+}
+
+// Close closes the idle connections of the factory's transport. Clients can still be created and used afterwards,
+// opening new connections.
+func (f *ClientFactory) Close() {
+	f.transport.CloseIdleConnections()
+}
@@ -0,0 +1,99 @@
+package gql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientFactory(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+
+	// the factory's transport is used even when another one is given
+	factory := NewClientFactory(ts.Endpoint(), "admin-secret", "test-client", nil, WithRoundTripper(failingTransport{}))
+	defer factory.Close()
+
+	var conns, reused atomic.Int32
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conns.Add(1)
+			if info.Reused {
+				reused.Add(1)
+			}
+		},
+	})
+
+	userID := uuid.New()
+	user := factory.PromotableClient(NewUserActor(&userID, "foo@bar.baz").SetSessionVariable("tenant", "acme"))
+	admin, err := user.Elevate("test")
+	assert.NoError(t, err)
+	provided := factory.ClientProvider(10).ClientFor(NewUserActor(&userID, "foo@bar.baz"))
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	for _, client := range []*ActorAwareClient{user, admin, factory.Client(NewAdminActor()), provided} {
+		assert.NoError(t, client.NamedQuery(ctx, "GetThing", &query, nil))
+	}
+
+	// every client, derived or not, reuses the connection of the first request
+	assert.Equal(t, int32(4), conns.Load())
+	assert.Equal(t, int32(3), reused.Load())
+
+	requests := ts.RequestsFor("GetThing")
+	if assert.Len(t, requests, 4) {
+		assert.Equal(t, RoleUser, requests[0].Role)
+		assert.Equal(t, "acme", requests[0].Headers.Get("X-Hasura-Tenant"))
+		assert.Equal(t, RoleAdmin, requests[1].Role)
+		assert.Equal(t, RoleAdmin, requests[2].Role)
+		assert.Equal(t, userID.String(), requests[3].UserID)
+	}
+}
+
+// failingTransport fails every round trip
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("not the factory's transport")
+}
+
+func TestClientFactoryAllocations(t *testing.T) {
+	options := []Option{WithQueryCaching(time.Minute), WithDeduplication(), WithPersistedQueries()}
+	factory := NewClientFactory("http://localhost/v1/graphql", "admin-secret", "test-client", nil, options...)
+	defer factory.Close()
+
+	userID := uuid.New()
+	actor := NewUserActor(&userID, "foo@bar.baz")
+	perClient := testing.AllocsPerRun(100, func() {
+		factory.Client(actor)
+	})
+	perChain := testing.AllocsPerRun(100, func() {
+		NewClient("http://localhost/v1/graphql", "admin-secret", actor, "test-client", options...)
+	})
+	// the factory only sets up the headers of each client, the round trippers are built once
+	assert.Less(t, perClient, perChain)
+}
+
+func BenchmarkClientFactory(b *testing.B) {
+	factory := NewClientFactory("http://localhost/v1/graphql", "admin-secret", "test-client", nil,
+		WithQueryCaching(time.Minute), WithDeduplication())
+	defer factory.Close()
+
+	userID := uuid.New()
+	actor := NewUserActor(&userID, "foo@bar.baz")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		factory.Client(actor)
+	}
+}
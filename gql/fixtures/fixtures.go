@@ -0,0 +1,205 @@
+//go:build integration
+// +build integration
+
+// Package fixtures seeds HGE with test data for integration tests, and removes it when the test finishes.
+//
+// Fixtures are declared per table, in Go or YAML, and inserted as admin through the untyped client in dependency
+// order:
+//
+//	# testdata/fixtures.yaml
+//	- table: users
+//	  rows:
+//	    - id: 6a1f0b5c-3b0e-4c0e-9d7a-3f1d2c4b5a69
+//	      email: foo@bar.baz
+//	- table: projects
+//	  depends_on: [users]
+//	  rows:
+//	    - name: my-project
+//	      owner_id: 6a1f0b5c-3b0e-4c0e-9d7a-3f1d2c4b5a69
+//
+// Table names are the graphql names HGE uses in the insert_<table> and delete_<table> root fields. Rows referenced by
+// other fixtures must set their primary key explicitly.
+package fixtures
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/hasura/hge-go-gql-client/gql/boolexpr"
+	untyped "github.com/shahidhk/gql"
+	"gopkg.in/yaml.v3"
+)
+
+// Table holds the rows to insert in a table
+type Table struct {
+	Name string `yaml:"table"`
+	// PrimaryKey is the column tracked to delete the inserted rows, id by default
+	PrimaryKey string `yaml:"primary_key"`
+	// DependsOn lists the tables whose fixtures must be inserted before this one
+	DependsOn []string         `yaml:"depends_on"`
+	Rows      []map[string]any `yaml:"rows"`
+}
+
+func (t Table) primaryKey() string {
+	if t.PrimaryKey == "" {
+		return "id"
+	}
+	return t.PrimaryKey
+}
+
+// Set is a group of table fixtures
+type Set []Table
+
+// Parse parses a YAML fixture set
+func Parse(data []byte) (Set, error) {
+	var set Set
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing fixtures: %w", err)
+	}
+	return set, nil
+}
+
+// ParseFile parses the YAML fixture set in the given file
+func ParseFile(path string) (Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// ordered returns the tables sorted so every table comes after the ones it depends on
+func (s Set) ordered() ([]Table, error) {
+	byName := map[string]Table{}
+	for _, table := range s {
+		byName[table.Name] = table
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := map[string]int{}
+	var ordered []Table
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("fixtures have a dependency cycle: %v", append(path, name))
+		case visited:
+			return nil
+		}
+		table, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("table %s depends on %s, which has no fixtures", path[len(path)-1], name)
+		}
+
+		state[name] = visiting
+		for _, dep := range table.DependsOn {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, table)
+		return nil
+	}
+
+	for _, table := range s {
+		if err := visit(table.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Inserted tracks the primary keys of the inserted rows
+type Inserted struct {
+	keys map[string][]any
+}
+
+// Keys returns the primary keys of the rows inserted in the given table, in the order they were declared
+func (i *Inserted) Keys(table string) []any {
+	return i.keys[table]
+}
+
+// Insert inserts the fixtures using the untyped client of the given admin client, and deletes them in reverse order
+// when the test finishes. The test fails right away if any insertion fails.
+func Insert(t testing.TB, admin *gql.ActorAwareClient, set Set) *Inserted {
+	t.Helper()
+
+	tables, err := set.ordered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := admin.Untyped()
+	inserted := &Inserted{keys: map[string][]any{}}
+	for _, table := range tables {
+		keys, err := insert(client, table)
+		if err != nil {
+			t.Fatalf("inserting %s fixtures: %s", table.Name, err)
+		}
+		inserted.keys[table.Name] = keys
+
+		table := table
+		t.Cleanup(func() {
+			if err := remove(client, table, keys); err != nil {
+				t.Errorf("deleting %s fixtures: %s", table.Name, err)
+			}
+		})
+	}
+	return inserted
+}
+
+func insert(client *untyped.Client, table Table) ([]any, error) {
+	if len(table.Rows) == 0 {
+		return nil, nil
+	}
+
+	pk := table.primaryKey()
+	request := untyped.Request{
+		OperationName: "InsertFixtures_" + table.Name,
+		Query: fmt.Sprintf("mutation InsertFixtures_%[1]s($objects: [%[1]s_insert_input!]!) { insert_%[1]s(objects: $objects) { returning { %[2]s } } }",
+			table.Name, pk),
+		Variables: map[string]any{"objects": table.Rows},
+	}
+
+	var data map[string]struct {
+		Returning []map[string]any `json:"returning"`
+	}
+	if err := client.Execute(request, &data); err != nil {
+		return nil, err
+	}
+
+	var keys []any
+	for _, row := range data["insert_"+table.Name].Returning {
+		keys = append(keys, row[pk])
+	}
+	return keys, nil
+}
+
+func remove(client *untyped.Client, table Table, keys []any) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	request := untyped.Request{
+		OperationName: "DeleteFixtures_" + table.Name,
+		Query: fmt.Sprintf("mutation DeleteFixtures_%[1]s($where: %[1]s_bool_exp!) { delete_%[1]s(where: $where) { affected_rows } }",
+			table.Name),
+		Variables: map[string]any{"where": map[string]any{table.primaryKey(): boolexpr.In(keys)}},
+	}
+
+	var data map[string]any
+	return client.Execute(request, &data)
+}
+
+// UserClient returns a client acting as a user with the given id and email, derived from the given admin client
+func UserClient(admin *gql.ActorAwareClient, userID uuid.UUID, email string) *gql.ActorAwareClient {
+	return admin.As(gql.NewUserActor(&userID, email))
+}
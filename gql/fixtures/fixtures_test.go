@@ -0,0 +1,82 @@
+//go:build integration
+// +build integration
+
+package fixtures
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+const sample = `
+- table: projects
+  depends_on: [users]
+  rows:
+    - name: my-project
+      owner_id: 6a1f0b5c-3b0e-4c0e-9d7a-3f1d2c4b5a69
+- table: users
+  rows:
+    - id: 6a1f0b5c-3b0e-4c0e-9d7a-3f1d2c4b5a69
+      email: foo@bar.baz
+`
+
+func TestInsertAndCleanup(t *testing.T) {
+	srv := gqltest.NewServer(t)
+	srv.OnOperation("InsertFixtures_users").Returns(`{"insert_users": {"returning": [{"id": "6a1f0b5c-3b0e-4c0e-9d7a-3f1d2c4b5a69"}]}}`)
+	srv.OnOperation("InsertFixtures_projects").Returns(`{"insert_projects": {"returning": [{"id": 42}]}}`)
+	srv.OnOperation("DeleteFixtures_projects").Returns(`{"delete_projects": {"affected_rows": 1}}`)
+	srv.OnOperation("DeleteFixtures_users").Returns(`{"delete_users": {"affected_rows": 1}}`)
+
+	set, err := Parse([]byte(sample))
+	assert.NoError(t, err)
+
+	admin := gql.NewAdminClientFromHost(srv.URL, "secret", "test")
+	t.Run("inserting", func(t *testing.T) {
+		inserted := Insert(t, admin, set)
+		assert.Equal(t, []any{float64(42)}, inserted.Keys("projects"))
+	})
+
+	var operations []string
+	for _, r := range srv.Requests() {
+		operations = append(operations, r.OperationName)
+		assert.Equal(t, gql.RoleAdmin, r.Role)
+	}
+	assert.Equal(t, []string{
+		"InsertFixtures_users",
+		"InsertFixtures_projects",
+		"DeleteFixtures_projects",
+		"DeleteFixtures_users",
+	}, operations)
+	assert.Equal(t, map[string]any{"where": map[string]any{"id": map[string]any{"_in": []any{float64(42)}}}},
+		srv.RequestsFor("DeleteFixtures_projects")[0].Variables)
+}
+
+func TestDependencyCycle(t *testing.T) {
+	_, err := Set{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"a"}},
+	}.ordered()
+	assert.ErrorContains(t, err, "cycle")
+}
+
+func TestUserClient(t *testing.T) {
+	srv := gqltest.NewServer(t)
+	srv.OnOperation("GetThing").Returns(`{"thing": {"field": 1}}`)
+
+	userID := uuid.New()
+	user := UserClient(gql.NewAdminClientFromHost(srv.URL, "secret", "test"), userID, "foo@bar.baz")
+
+	var q struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	assert.NoError(t, user.NamedQuery(context.TODO(), "GetThing", &q, nil))
+	srv.AssertCalledAs(t, "GetThing", gql.RoleUser)
+	assert.Equal(t, userID.String(), srv.Requests()[0].UserID)
+}
@@ -0,0 +1,242 @@
+// Package gqlmock provides a programmable gql.Client for unit tests. Tests declare the operations they expect and
+// what they return, and unmet expectations fail the test when it finishes.
+//
+//	m := gqlmock.New(t)
+//	m.ExpectNamedQuery("GetUser").WithVariables(map[string]any{"id": 1}).Returns(`{"users_by_pk": {"name": "foo"}}`)
+//	svc := NewService(m)
+package gqlmock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+)
+
+// ErrUnexpectedCall is returned for calls not matching any expectation
+var ErrUnexpectedCall = errors.New("gqlmock: unexpected call")
+
+type operation string
+
+const (
+	query    operation = "query"
+	mutation operation = "mutation"
+)
+
+// Expectation is an operation a test expects to be called, and its result
+type Expectation struct {
+	op        operation
+	name      string
+	variables map[string]any
+	data      []byte
+	err       error
+	times     int
+	calls     int
+}
+
+// WithVariables restricts the expectation to calls with the given variables. Variables are compared by their json
+// representation. By default, calls with any variables match.
+func (e *Expectation) WithVariables(variables map[string]any) *Expectation {
+	e.variables = variables
+	return e
+}
+
+// Returns sets the data returned by the call, as raw json. It's unmarshalled into the caller's struct.
+func (e *Expectation) Returns(data string) *Expectation {
+	e.data = []byte(data)
+	return e
+}
+
+// ReturnsError makes the call fail with the given error, typically a graphql.Errors. Data set with Returns is still
+// unmarshalled, as it happens with partial responses.
+func (e *Expectation) ReturnsError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times sets how many times the operation is expected to be called, once by default
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// AnyTimes allows the operation to be called any number of times, including none
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = -1
+	return e
+}
+
+func (e *Expectation) String() string {
+	if e.name == "" {
+		return "anonymous " + string(e.op)
+	}
+	return fmt.Sprintf("%s %s", e.op, e.name)
+}
+
+func (e *Expectation) satisfied() bool {
+	return e.times < 0 || e.calls >= e.times
+}
+
+func (e *Expectation) exhausted() bool {
+	return e.times >= 0 && e.calls >= e.times
+}
+
+func (e *Expectation) matches(op operation, name string, variables map[string]any) bool {
+	if e.op != op || e.name != name {
+		return false
+	}
+	if e.variables == nil {
+		return true
+	}
+	expected, _ := json.Marshal(e.variables)
+	actual, _ := json.Marshal(variables)
+	return bytes.Equal(expected, actual)
+}
+
+type options struct {
+	inOrder bool
+}
+
+type Option func(*options)
+
+// InOrder makes the mock fail calls made in a different order than their expectations were declared
+func InOrder() Option {
+	return func(opts *options) {
+		opts.inOrder = true
+	}
+}
+
+// Mock is a gql.Client returning the results declared by the test
+type Mock struct {
+	t    testing.TB
+	opts options
+
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// New creates a mock that checks its expectations were met when the test finishes
+func New(t testing.TB, mockOptions ...Option) *Mock {
+	var opts options
+	for _, apply := range mockOptions {
+		apply(&opts)
+	}
+
+	m := &Mock{t: t, opts: opts}
+	t.Cleanup(m.AssertExpectations)
+	return m
+}
+
+// ExpectQuery expects a call to Query
+func (m *Mock) ExpectQuery() *Expectation {
+	return m.expect(query, "")
+}
+
+// ExpectNamedQuery expects a call to NamedQuery or NamedQueryRaw
+func (m *Mock) ExpectNamedQuery(name string) *Expectation {
+	return m.expect(query, name)
+}
+
+// ExpectMutate expects a call to Mutate
+func (m *Mock) ExpectMutate() *Expectation {
+	return m.expect(mutation, "")
+}
+
+// ExpectNamedMutate expects a call to NamedMutate or NamedMutateRaw
+func (m *Mock) ExpectNamedMutate(name string) *Expectation {
+	return m.expect(mutation, name)
+}
+
+func (m *Mock) expect(op operation, name string) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := &Expectation{op: op, name: name, times: 1}
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// AssertExpectations fails the test if any expectation was not met. It's called automatically when the test
+// finishes.
+func (m *Mock) AssertExpectations() {
+	m.t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.expectations {
+		if !e.satisfied() {
+			m.t.Errorf("gqlmock: expected %s to be called %d times, but it was called %d times", e, e.times, e.calls)
+		}
+	}
+}
+
+// call finds the expectation matching the call and returns its result
+func (m *Mock) call(op operation, name string, variables map[string]any) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var match *Expectation
+	for _, e := range m.expectations {
+		if e.exhausted() {
+			continue
+		}
+		if e.matches(op, name, variables) {
+			match = e
+			break
+		}
+		if m.opts.inOrder && !e.satisfied() {
+			m.t.Errorf("gqlmock: called %s %q out of order, expected %s first", op, name, e)
+			return nil, fmt.Errorf("%w: %s %q out of order", ErrUnexpectedCall, op, name)
+		}
+	}
+
+	if match == nil {
+		m.t.Errorf("gqlmock: unexpected call to %s %q with variables %v", op, name, variables)
+		return nil, fmt.Errorf("%w: %s %q", ErrUnexpectedCall, op, name)
+	}
+	match.calls++
+	return match.data, match.err
+}
+
+func (m *Mock) do(op operation, name string, v interface{}, variables map[string]interface{}) error {
+	data, err := m.call(op, name, variables)
+	if len(data) > 0 {
+		if decodeErr := graphql.UnmarshalGraphQL(data, v); decodeErr != nil && err == nil {
+			err = decodeErr
+		}
+	}
+	return err
+}
+
+func (m *Mock) Query(ctx context.Context, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return m.do(query, "", q, variables)
+}
+
+func (m *Mock) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return m.do(query, name, q, variables)
+}
+
+func (m *Mock) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	return m.call(query, name, variables)
+}
+
+func (m *Mock) Mutate(ctx context.Context, mut interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return m.do(mutation, "", mut, variables)
+}
+
+func (m *Mock) NamedMutate(ctx context.Context, name string, mut interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return m.do(mutation, name, mut, variables)
+}
+
+func (m *Mock) NamedMutateRaw(ctx context.Context, name string, mut interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	return m.call(mutation, name, variables)
+}
+
+// assert that *Mock implements gql.Client
+var _ gql.Client = &Mock{}
@@ -0,0 +1,95 @@
+package gqlmock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeT records the failures of the mock instead of failing the test
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeT) finish() {
+	for _, fn := range f.cleanups {
+		fn()
+	}
+}
+
+type userQuery struct {
+	User struct {
+		Name string `graphql:"name"`
+	} `graphql:"users_by_pk(id: $id)"`
+}
+
+func TestMock(t *testing.T) {
+	m := New(t)
+	m.ExpectNamedQuery("GetUser").WithVariables(map[string]any{"id": 1}).Returns(`{"users_by_pk": {"name": "foo"}}`)
+	m.ExpectNamedQuery("GetUser").WithVariables(map[string]any{"id": 2}).Returns(`{"users_by_pk": {"name": "bar"}}`).Times(2)
+	m.ExpectNamedMutate("DeleteUser").ReturnsError(graphql.Errors{{Message: "permission denied"}})
+	m.ExpectQuery().AnyTimes()
+
+	var q userQuery
+	assert.NoError(t, m.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 2}))
+	assert.Equal(t, "bar", q.User.Name)
+	assert.NoError(t, m.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+	assert.Equal(t, "foo", q.User.Name)
+
+	raw, err := m.NamedQueryRaw(context.TODO(), "GetUser", &q, map[string]any{"id": 2})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"users_by_pk": {"name": "bar"}}`, string(raw))
+
+	var errs graphql.Errors
+	assert.ErrorAs(t, m.NamedMutate(context.TODO(), "DeleteUser", &q, map[string]any{"id": 1}), &errs)
+	assert.Equal(t, "permission denied", errs[0].Message)
+}
+
+func TestMockFailures(t *testing.T) {
+	ft := &fakeT{}
+	m := New(ft)
+	m.ExpectNamedQuery("GetUser")
+	m.ExpectNamedQuery("GetOther")
+
+	var q userQuery
+	err := m.NamedQuery(context.TODO(), "GetUser", &q, nil)
+	assert.NoError(t, err)
+	err = m.NamedQuery(context.TODO(), "GetUser", &q, nil)
+	assert.True(t, errors.Is(err, ErrUnexpectedCall))
+
+	ft.finish()
+	assert.Len(t, ft.errors, 2)
+	assert.Contains(t, ft.errors[0], "unexpected call")
+	assert.Contains(t, ft.errors[1], "expected query GetOther to be called 1 times")
+}
+
+func TestMockInOrder(t *testing.T) {
+	ft := &fakeT{}
+	m := New(ft, InOrder())
+	m.ExpectNamedQuery("First")
+	m.ExpectNamedMutate("Second")
+
+	var q userQuery
+	err := m.NamedMutate(context.TODO(), "Second", &q, nil)
+	assert.True(t, errors.Is(err, ErrUnexpectedCall))
+	assert.Contains(t, ft.errors[0], "out of order")
+
+	assert.NoError(t, m.NamedQuery(context.TODO(), "First", &q, nil))
+	assert.NoError(t, m.NamedMutate(context.TODO(), "Second", &q, nil))
+}
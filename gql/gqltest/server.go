@@ -0,0 +1,297 @@
+// Package gqltest provides a fake Hasura GraphQL Engine to unit test code using gql clients. The server records the
+// requests it receives and replies with canned responses registered per operation name or document.
+//
+//	srv := gqltest.NewServer(t)
+//	srv.OnOperation("GetUser").Returns(`{"users_by_pk": {"id": 1}}`)
+//	client := gql.NewClient(srv.Endpoint(), "secret", gql.NewUserActor(&id, "foo@bar.baz"), "test")
+//	...
+//	srv.AssertCalledAs(t, "GetUser", gql.RoleUser)
+package gqltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Request is a graphql request received by the Server
+type Request struct {
+	Headers       http.Header
+	Role          string
+	UserID        string
+	OperationName string
+	Query         string
+	Variables     map[string]any
+}
+
+// Error is a graphql error, with the extensions set by HGE
+type Error struct {
+	Message string
+	Code    string
+	Path    string
+}
+
+// Handler is the canned response to the requests matching it. It can be changed while the server is serving
+// requests.
+type Handler struct {
+	// mu is the mutex of the server the handler is registered in
+	mu      *sync.Mutex
+	match   func(Request) bool
+	data    json.RawMessage
+	errors  []Error
+	status  int
+	latency time.Duration
+	headers http.Header
+}
+
+// Returns sets the data field of the response, as raw json
+func (h *Handler) Returns(data string) *Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.data = json.RawMessage(data)
+	return h
+}
+
+// ReturnsErrors sets the errors field of the response
+func (h *Handler) ReturnsErrors(errs ...Error) *Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errors = errs
+	return h
+}
+
+// WithStatus sets the http status code of the response, 200 by default
+func (h *Handler) WithStatus(status int) *Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status = status
+	return h
+}
+
+// WithLatency delays the response by the given duration, or until the client gives up
+func (h *Handler) WithLatency(latency time.Duration) *Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latency = latency
+	return h
+}
+
+// WithHeader sets a header in the response
+func (h *Handler) WithHeader(name, value string) *Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.headers.Set(name, value)
+	return h
+}
+
+// Server is a fake HGE. It serves graphql requests on any path, so both its URL (as a host) and its Endpoint can be
+// used with gql constructors, and health checks on GET /healthz under any prefix.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	handlers  []*Handler
+	requests  []Request
+	unmatched []Request
+	unhealthy bool
+}
+
+// NewServer starts a fake HGE, closed when the test finishes. The requests no handler matched fail the test then.
+func NewServer(t testing.TB) *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(func() {
+		s.Close()
+		// reported once the server is closed, as requests can keep coming from other goroutines until then
+		for _, req := range s.unmatchedRequests() {
+			t.Errorf("gqltest: no handler for operation %q: %s", req.OperationName, req.Query)
+		}
+	})
+	return s
+}
+
+// Endpoint is the url of the graphql endpoint, as HGE serves it
+func (s *Server) Endpoint() string {
+	return s.URL + "/v1/graphql"
+}
+
+// SetHealthy sets whether the server answers its health checks with a 200, as it does by default, or a 503
+func (s *Server) SetHealthy(healthy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unhealthy = !healthy
+}
+
+// On registers a handler for the requests the given function matches. When several handlers match a request, the
+// most recently registered one is used.
+func (s *Server) On(match func(Request) bool) *Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := &Handler{mu: &s.mu, match: match, status: http.StatusOK, headers: http.Header{}}
+	s.handlers = append(s.handlers, h)
+	return h
+}
+
+// OnOperation registers a handler for the requests with the given operation name
+func (s *Server) OnOperation(operationName string) *Handler {
+	return s.On(func(r Request) bool {
+		return r.OperationName == operationName
+	})
+}
+
+// OnDocument registers a handler for the requests whose document contains the given text
+func (s *Server) OnDocument(text string) *Handler {
+	return s.On(func(r Request) bool {
+		return strings.Contains(r.Query, text)
+	})
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) unmatchedRequests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.unmatched...)
+}
+
+// RequestsFor returns the requests received so far with the given operation name
+func (s *Server) RequestsFor(operationName string) []Request {
+	var requests []Request
+	for _, r := range s.Requests() {
+		if r.OperationName == operationName {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// AssertCalled asserts the server received the given operation
+func (s *Server) AssertCalled(t testing.TB, operationName string) bool {
+	t.Helper()
+	return assert.NotEmpty(t, s.RequestsFor(operationName), "operation %s was not called", operationName)
+}
+
+// AssertNotCalled asserts the server didn't receive the given operation
+func (s *Server) AssertNotCalled(t testing.TB, operationName string) bool {
+	t.Helper()
+	return assert.Empty(t, s.RequestsFor(operationName), "operation %s was called", operationName)
+}
+
+// AssertCalledAs asserts every request for the given operation was made with the given role
+func (s *Server) AssertCalledAs(t testing.TB, operationName string, role string) bool {
+	t.Helper()
+	requests := s.RequestsFor(operationName)
+	if !assert.NotEmpty(t, requests, "operation %s was not called", operationName) {
+		return false
+	}
+	for _, r := range requests {
+		if !assert.Equal(t, role, r.Role, "operation %s called with an unexpected role", operationName) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/healthz") {
+		s.mu.Lock()
+		unhealthy := s.unhealthy
+		s.mu.Unlock()
+		if unhealthy {
+			http.Error(w, "ERROR", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("OK"))
+		return
+	}
+
+	var in struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := Request{
+		Headers:       r.Header.Clone(),
+		Role:          r.Header.Get("x-hasura-role"),
+		UserID:        r.Header.Get("x-hasura-user-id"),
+		OperationName: in.OperationName,
+		Query:         in.Query,
+		Variables:     in.Variables,
+	}
+
+	// the handler is copied, as it can be changed while the response is written
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var handler Handler
+	matched := false
+	for i := len(s.handlers) - 1; i >= 0; i-- {
+		if s.handlers[i].match(req) {
+			handler = *s.handlers[i]
+			handler.headers = handler.headers.Clone()
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		s.unmatched = append(s.unmatched, req)
+		handler = Handler{
+			status: http.StatusOK,
+			errors: []Error{{Message: fmt.Sprintf("no handler for operation %q", req.OperationName), Code: "validation-failed"}},
+		}
+	}
+	s.mu.Unlock()
+
+	if handler.latency > 0 {
+		select {
+		case <-time.After(handler.latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	for name, values := range handler.headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(handler.status)
+	_ = json.NewEncoder(w).Encode(handler.response())
+}
+
+func (h *Handler) response() map[string]any {
+	out := map[string]any{}
+	if h.data != nil {
+		out["data"] = h.data
+	}
+	if len(h.errors) > 0 {
+		errs := make([]map[string]any, len(h.errors))
+		for i, e := range h.errors {
+			errs[i] = map[string]any{
+				"message": e.Message,
+				"extensions": map[string]string{
+					"code": e.Code,
+					"path": e.Path,
+				},
+			}
+		}
+		out["errors"] = errs
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package gqltest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+type userQuery struct {
+	User struct {
+		Name string `graphql:"name"`
+	} `graphql:"users_by_pk(id: $id)"`
+}
+
+func TestServer(t *testing.T) {
+	srv := gqltest.NewServer(t)
+	srv.OnOperation("GetUser").Returns(`{"users_by_pk": {"name": "foo"}}`)
+
+	userID := uuid.New()
+	cl := gql.NewClient(srv.Endpoint(), "secret", gql.NewUserActor(&userID, "foo@bar.baz"), "test")
+
+	var q userQuery
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+	assert.Equal(t, "foo", q.User.Name)
+
+	srv.AssertCalledAs(t, "GetUser", gql.RoleUser)
+	srv.AssertNotCalled(t, "GetOther")
+
+	requests := srv.RequestsFor("GetUser")
+	assert.Equal(t, userID.String(), requests[0].UserID)
+	assert.Equal(t, map[string]any{"id": float64(1)}, requests[0].Variables)
+	assert.Equal(t, "query GetUser($id:Int!){users_by_pk(id: $id){name}}", requests[0].Query)
+}
+
+func TestServerErrorsAndStatus(t *testing.T) {
+	srv := gqltest.NewServer(t)
+	srv.OnDocument("users_by_pk").ReturnsErrors(gqltest.Error{Message: "field not found", Code: "validation-failed"})
+	cl := gql.NewAdminClientFromHost(srv.URL, "secret", "test")
+
+	var q userQuery
+	err := cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1})
+	var errs graphql.Errors
+	assert.ErrorAs(t, err, &errs)
+	assert.Equal(t, "field not found", errs[0].Message)
+	assert.Equal(t, "validation-failed", errs[0].Extensions["code"])
+
+	// the most recently registered handler wins
+	srv.OnOperation("GetUser").WithStatus(http.StatusServiceUnavailable).WithLatency(5 * time.Millisecond)
+	err = cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1})
+	assert.ErrorContains(t, err, "503")
+}
+
+// cleanupRecorder runs the cleanups of a server on demand, recording the errors they report
+type cleanupRecorder struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (r *cleanupRecorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *cleanupRecorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestServerUnmatchedRequests(t *testing.T) {
+	rec := &cleanupRecorder{TB: t}
+	srv := gqltest.NewServer(rec)
+	h := srv.OnOperation("GetUser").Returns(`{"users_by_pk": {"name": "foo"}}`)
+	cl := gql.NewAdminClientFromHost(srv.URL, "secret", "test")
+
+	// handlers can be changed while requests are served
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var q userQuery
+			_ = cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1})
+		}()
+		go func() {
+			defer wg.Done()
+			h.WithHeader("X-Served-By", "gqltest").WithStatus(http.StatusOK)
+		}()
+	}
+	wg.Wait()
+
+	// unmatched requests are only reported when the test finishes
+	var q userQuery
+	assert.Error(t, cl.NamedQuery(context.TODO(), "GetOther", &q, map[string]any{"id": 1}))
+	assert.Empty(t, rec.errors)
+
+	for _, cleanup := range rec.cleanups {
+		cleanup()
+	}
+	if assert.Len(t, rec.errors, 1) {
+		assert.Contains(t, rec.errors[0], `no handler for operation "GetOther"`)
+	}
+}
@@ -0,0 +1,86 @@
+package gql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrHeaderNotAllowed is returned, without sending the request, when the context sets a header the client's
+// HeaderPolicy rejects
+var ErrHeaderNotAllowed = errors.New("header not allowed")
+
+// HeaderViolation describes a header rejected by a HeaderPolicy
+type HeaderViolation struct {
+	// Header is the canonical name of the rejected header. Its value isn't reported, as it might be a secret.
+	Header string
+	Reason string
+	// Actor is the actor of the client the request was made with
+	Actor *Actor
+}
+
+// HeaderPolicy decides which headers the context, through WithHeader(s) and CallHeader, can set on the requests of
+// a client. Session headers (x-hasura-*) are protected by default: they're set by the client for its actor, and only
+// deriving a new client, with AsAdmin for instance, can change them.
+//
+// The zero value only protects session headers.
+type HeaderPolicy struct {
+	// Allow lists the only headers the context can set. Any header can be set when empty.
+	Allow []string
+	// Deny lists headers the context can't set
+	Deny []string
+	// AllowSessionHeaders lets the context set x-hasura-* headers, which allows escalating the client privileges
+	AllowSessionHeaders bool
+	// OnViolation is called for every rejected header, before the request fails
+	OnViolation func(ctx context.Context, violation HeaderViolation)
+}
+
+// WithHeaderPolicy sets the policy for the headers set in the context of the requests
+func WithHeaderPolicy(policy HeaderPolicy) Option {
+	return func(opts *options) {
+		opts.headerPolicy = policy
+	}
+}
+
+// reason returns why the header isn't allowed, or an empty string if it is
+func (p HeaderPolicy) reason(name string) string {
+	name = http.CanonicalHeaderKey(name)
+	switch {
+	case !p.AllowSessionHeaders && strings.HasPrefix(name, "X-Hasura-"):
+		return "session headers can't be set from the context"
+	case containsHeader(p.Deny, name):
+		return "header is denied"
+	case len(p.Allow) > 0 && !containsHeader(p.Allow, name):
+		return "header isn't allowed"
+	}
+	return ""
+}
+
+// check fails for the first header the policy rejects, reporting every rejected header to OnViolation
+func (p HeaderPolicy) check(ctx context.Context, actor *Actor, headers http.Header) error {
+	var err error
+	for name := range headers {
+		reason := p.reason(name)
+		if reason == "" {
+			continue
+		}
+		if p.OnViolation != nil {
+			p.OnViolation(ctx, HeaderViolation{Header: name, Reason: reason, Actor: actor})
+		}
+		if err == nil {
+			err = fmt.Errorf("%w: %s: %s", ErrHeaderNotAllowed, name, reason)
+		}
+	}
+	return err
+}
+
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package gql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	sampleUUID := uuid.New()
+	actor := NewUserActor(&sampleUUID, "foo@bar.baz")
+
+	// session headers are protected by default
+	user := NewClient(ts.Endpoint(), "admin-secret", actor, "test-client")
+	err := user.Query(WithHeader(context.TODO(), "X-HASURA-ROLE", RoleAdmin), &query, nil)
+	assert.ErrorIs(t, err, ErrHeaderNotAllowed)
+	err = user.Query(WithCallOptions(context.TODO(), CallHeader("x-hasura-user-id", uuid.NewString())), &query, nil)
+	assert.ErrorIs(t, err, ErrHeaderNotAllowed)
+	assert.Empty(t, ts.Requests())
+
+	var violations []HeaderViolation
+	restricted := NewClient(ts.Endpoint(), "admin-secret", actor, "test-client", WithHeaderPolicy(HeaderPolicy{
+		Allow: []string{"x-request-id", "x-tenant"},
+		Deny:  []string{"x-tenant"},
+		OnViolation: func(ctx context.Context, violation HeaderViolation) {
+			violations = append(violations, violation)
+		},
+	}))
+	assert.NoError(t, restricted.Query(WithHeader(context.TODO(), "X-Request-Id", "42"), &query, nil))
+	assert.ErrorIs(t, restricted.Query(WithHeader(context.TODO(), "x-tenant", "foo"), &query, nil), ErrHeaderNotAllowed)
+	assert.ErrorIs(t, restricted.Query(WithHeader(context.TODO(), "x-other", "foo"), &query, nil), ErrHeaderNotAllowed)
+	assert.Equal(t, []HeaderViolation{
+		{Header: "X-Tenant", Reason: "header is denied", Actor: actor},
+		{Header: "X-Other", Reason: "header isn't allowed", Actor: actor},
+	}, violations)
+
+	permissive := NewClient(ts.Endpoint(), "admin-secret", actor, "test-client", WithHeaderPolicy(HeaderPolicy{AllowSessionHeaders: true}))
+	assert.NoError(t, permissive.Query(WithHeader(context.TODO(), "x-hasura-role", "reader"), &query, nil))
+
+	requests := ts.Requests()
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, "42", requests[0].Headers.Get("X-Request-Id"))
+		assert.Equal(t, "reader", requests[1].Role)
+	}
+}
@@ -0,0 +1,82 @@
+package gql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonate(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	supportID, userID, samlID := uuid.New(), uuid.New(), uuid.New()
+	support := NewUserActor(&supportID, "support@foo.bar").AsAdmin()
+	target := NewUserActor(&userID, "user@foo.bar")
+	saml := NewUserActor(&samlID, "saml@foo.bar")
+	saml.UsesSAML = true
+
+	log := &auditLog{}
+	admin := NewPromotableClient(ts.Endpoint(), "admin-secret", support, "test-client", WithAuditSink(log))
+	impersonating, err := admin.Impersonate(target, nil, "ticket 42")
+	assert.NoError(t, err)
+	assert.Equal(t, target, impersonating.Actor)
+	assert.NoError(t, impersonating.NamedQuery(context.TODO(), "GetThing", &query, nil))
+
+	// the impersonation is still visible once elevated again
+	elevated, err := impersonating.Elevate("ticket 42 follow-up")
+	assert.NoError(t, err)
+	assert.NoError(t, elevated.NamedQuery(context.TODO(), "GetThing", &query, nil))
+
+	requests := ts.RequestsFor("GetThing")
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, RoleUser, requests[0].Role)
+		assert.Equal(t, userID.String(), requests[0].UserID)
+		assert.Equal(t, supportID.String(), requests[0].Headers.Get(XHasuraImpersonatedBy))
+		assert.Equal(t, supportID.String(), requests[1].Headers.Get(XHasuraImpersonatedBy))
+	}
+	if assert.Len(t, log.events, 4) {
+		assert.Equal(t, AuditImpersonation, log.events[0].Type)
+		assert.Equal(t, support, log.events[0].Actor)
+		assert.Equal(t, target, log.events[0].Target)
+		assert.Equal(t, "ticket 42", log.events[0].Reason)
+		assert.Contains(t, log.events[0].Caller, "TestImpersonate")
+		assert.Equal(t, AuditElevatedOperation, log.events[1].Type)
+		assert.Equal(t, AuditElevation, log.events[2].Type)
+	}
+
+	_, err = admin.Impersonate(NewAdminActor(), nil, "")
+	assert.ErrorIs(t, err, ErrImpersonationDenied)
+	// an actor allowed to act as admin is an admin
+	sneaky := NewUserActor(&userID, "user@foo.bar")
+	sneaky.AllowedRoles = []string{RoleAdmin}
+	_, err = admin.Impersonate(sneaky, nil, "")
+	assert.ErrorIs(t, err, ErrImpersonationDenied)
+	_, err = admin.Impersonate(saml, DefaultImpersonationPolicy, "")
+	assert.ErrorIs(t, err, ErrImpersonationDenied)
+	_, err = admin.Impersonate(saml, ImpersonationRules{AllowSAMLTargets: true}, "")
+	assert.NoError(t, err)
+
+	user := NewPromotableClient(ts.Endpoint(), "admin-secret", target, "test-client")
+	_, err = user.Impersonate(saml, ImpersonationRules{AllowSAMLTargets: true}, "")
+	assert.ErrorIs(t, err, ErrImpersonationDenied)
+	_, err = user.Impersonate(saml, ImpersonationRules{ImpersonatorRoles: []string{RoleUser}, AllowSAMLTargets: true}, "")
+	assert.NoError(t, err)
+
+	// allowed roles can impersonate too
+	agent := NewUserActor(&supportID, "support@foo.bar")
+	agent.AllowedRoles = []string{"support"}
+	_, err = NewPromotableClient(ts.Endpoint(), "admin-secret", agent, "test-client").Impersonate(target, ImpersonationRules{ImpersonatorRoles: []string{"support"}}, "")
+	assert.NoError(t, err)
+
+	_, err = NewAdminClient(ts.Endpoint(), "admin-secret", "test-client", WithElevationReasonRequired()).Impersonate(target, nil, "")
+	assert.ErrorIs(t, err, ErrElevationReasonRequired)
+}
@@ -0,0 +1,156 @@
+//go:build integration
+// +build integration
+
+// Package permtest runs an operation against HGE on behalf of several actors, and checks each of them gets the
+// outcome HGE permissions should give them.
+//
+//	permtest.Run(t, admin, "GetProjects", func(ctx context.Context, cl *gql.ActorAwareClient) (int, error) {
+//		var q struct {
+//			Projects []struct{ ID uuid.UUID } `graphql:"projects"`
+//		}
+//		err := cl.NamedQuery(ctx, "GetProjects", &q, nil)
+//		return len(q.Projects), err
+//	},
+//		permtest.Case{Name: "owner", Actor: gql.NewUserActor(&ownerID, "owner@foo.bar"), Expect: permtest.Allowed},
+//		permtest.Case{Name: "other user", Actor: gql.NewUserActor(&otherID, "other@foo.bar"), Expect: permtest.Filtered},
+//		permtest.Case{Name: "public", Actor: &gql.Actor{Role: gql.RolePublic}, Expect: permtest.Denied},
+//	)
+package permtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+)
+
+// Outcome is the result of running an operation as an actor
+type Outcome int
+
+const (
+	// Failed is the outcome of operations failing for reasons other than permissions
+	Failed Outcome = iota
+	// Allowed operations return or affect as many rows as they do for admins
+	Allowed
+	// Denied operations fail with a permission error, or a validation error about fields missing in the role's schema
+	Denied
+	// Filtered operations succeed, but return or affect fewer rows than they do for admins
+	Filtered
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Allowed:
+		return "allowed"
+	case Denied:
+		return "denied"
+	case Filtered:
+		return "filtered"
+	}
+	return "failed"
+}
+
+// deniedCodes are the error codes HGE uses when a role can't run an operation, as rows not passing the permission
+// checks fail the mutation.
+var deniedCodes = map[string]bool{
+	"permission-error": true,
+	"access-denied":    true,
+}
+
+// denied tells whether the error is HGE refusing the operation to the role. Fields a role has no permission on don't
+// exist in its schema, which HGE reports as a validation error, like typos, wrong variables and schema drift: only
+// those about missing fields count, as the operation succeeded for admins.
+func denied(e *graphql.Error) bool {
+	code, _ := e.Extensions["code"].(string)
+	if code == "validation-failed" {
+		return strings.Contains(e.Message, "not found in type")
+	}
+	return deniedCodes[code]
+}
+
+// Operation runs the operation under test with the given client, returning the number of rows it returned or
+// affected.
+type Operation func(ctx context.Context, client *gql.ActorAwareClient) (rows int, err error)
+
+// Case is an actor running the operation, and the outcome it should get
+type Case struct {
+	Name   string
+	Actor  *gql.Actor
+	Expect Outcome
+}
+
+type result struct {
+	Case
+	got  Outcome
+	rows int
+	err  error
+}
+
+// Run runs the operation as admin, to learn how many rows an unrestricted actor gets, and then as every actor in
+// the cases, using clients derived from admin with As. It fails the test with a report of the whole matrix when any
+// actor gets an unexpected outcome.
+//
+// Operations that modify data are run once per case, so they should be idempotent or the cases ordered accordingly.
+func Run(t testing.TB, admin *gql.ActorAwareClient, name string, op Operation, cases ...Case) {
+	t.Helper()
+	ctx := context.Background()
+
+	baseline, err := op(ctx, admin)
+	if err != nil {
+		t.Fatalf("%s: running the operation as admin: %s", name, err)
+	}
+
+	results := make([]result, len(cases))
+	failed := false
+	for i, c := range cases {
+		rows, err := op(ctx, admin.As(c.Actor))
+		results[i] = result{Case: c, got: outcome(baseline, rows, err), rows: rows, err: err}
+		failed = failed || results[i].got != c.Expect
+	}
+
+	if failed {
+		t.Errorf("%s: unexpected permissions (an unrestricted actor gets %d rows)\n%s", name, baseline, report(results))
+	}
+}
+
+func outcome(baseline, rows int, err error) Outcome {
+	if err != nil {
+		var errs graphql.Errors
+		if errors.As(err, &errs) {
+			for i := range errs {
+				if denied(&errs[i]) {
+					return Denied
+				}
+			}
+		}
+		return Failed
+	}
+	if rows < baseline {
+		return Filtered
+	}
+	return Allowed
+}
+
+func report(results []result) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tACTOR\tROLE\tEXPECTED\tGOT\tROWS\tERROR")
+	for _, r := range results {
+		mark := ""
+		if r.got != r.Expect {
+			mark = "✗"
+		}
+		var errMsg string
+		if r.err != nil {
+			errMsg = r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", mark, r.Name, r.Actor.Role, r.Expect, r.got, r.rows, errMsg)
+	}
+	_ = w.Flush()
+	return b.String()
+}
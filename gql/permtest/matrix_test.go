@@ -0,0 +1,73 @@
+//go:build integration
+// +build integration
+
+package permtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeT records the failures of the harness instead of failing the test
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func getProjects(ctx context.Context, cl *gql.ActorAwareClient) (int, error) {
+	var q struct {
+		Projects []struct {
+			ID int `graphql:"id"`
+		} `graphql:"projects"`
+	}
+	err := cl.NamedQuery(ctx, "GetProjects", &q, nil)
+	return len(q.Projects), err
+}
+
+func TestRun(t *testing.T) {
+	owner, other := uuid.New(), uuid.New()
+
+	srv := gqltest.NewServer(t)
+	srv.OnOperation("GetProjects").Returns(`{"projects": [{"id": 1}, {"id": 2}]}`)
+	srv.On(func(r gqltest.Request) bool { return r.UserID == owner.String() }).Returns(`{"projects": [{"id": 1}, {"id": 2}]}`)
+	srv.On(func(r gqltest.Request) bool { return r.UserID == other.String() }).Returns(`{"projects": [{"id": 2}]}`)
+	srv.On(func(r gqltest.Request) bool { return r.Role == gql.RolePublic }).
+		ReturnsErrors(gqltest.Error{Message: "field 'projects' not found in type: 'query_root'", Code: "validation-failed"})
+
+	admin := gql.NewAdminClientFromHost(srv.URL, "secret", "test")
+	cases := []Case{
+		{Name: "owner", Actor: gql.NewUserActor(&owner, "owner@foo.bar"), Expect: Allowed},
+		{Name: "other user", Actor: gql.NewUserActor(&other, "other@foo.bar"), Expect: Filtered},
+		{Name: "public", Actor: &gql.Actor{Role: gql.RolePublic}, Expect: Denied},
+	}
+
+	Run(t, admin, "GetProjects", getProjects, cases...)
+
+	ft := &fakeT{}
+	cases[1].Expect = Allowed
+	Run(ft, admin, "GetProjects", getProjects, cases...)
+	assert.Len(t, ft.errors, 1)
+	assert.Contains(t, ft.errors[0], "✗  other user  user    allowed   filtered  1")
+
+	// validation errors other than missing fields are failures, not denials
+	srv.On(func(r gqltest.Request) bool { return r.Role == gql.RolePublic }).
+		ReturnsErrors(gqltest.Error{Message: "variable 'id' is declared as 'Int!', but used where 'uuid!' is expected", Code: "validation-failed"})
+	ft = &fakeT{}
+	cases[1].Expect = Filtered
+	Run(ft, admin, "GetProjects", getProjects, cases...)
+	assert.Len(t, ft.errors, 1)
+	assert.Contains(t, ft.errors[0], "✗  public")
+	assert.Contains(t, ft.errors[0], "denied    failed")
+}
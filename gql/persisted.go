@@ -0,0 +1,219 @@
+package gql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WithPersistedQueries makes the client use automatic persisted queries: requests carry the sha256 hash of the
+// document instead of the document itself, and it's only sent when the server replies it doesn't know the hash
+// yet.
+func WithPersistedQueries() Option {
+	return func(opts *options) {
+		opts.persisted = true
+	}
+}
+
+// WithQueryCollector records every document sent by the client in the given collector, see
+// QueryCollector.WriteAllowlist
+func WithQueryCollector(collector *QueryCollector) Option {
+	return func(opts *options) {
+		opts.collector = collector
+	}
+}
+
+type persistedQuery struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+type persistedPayload struct {
+	Query         string         `json:"query,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+	Extensions    struct {
+		PersistedQuery persistedQuery `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+type persistedRoundTripper struct {
+	rt http.RoundTripper
+}
+
+func newPersistedRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	return persistedRoundTripper{rt: rt}
+}
+
+func (p persistedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	original, _, err := readPayload(req)
+	if err != nil {
+		return p.rt.RoundTrip(req)
+	}
+	// the round trippers below only see the hash, so they're told the operation type
+	req = markMutation(req, original.Query)
+
+	persisted := persistedPayload{
+		Variables:     original.Variables,
+		OperationName: original.OperationName,
+	}
+	persisted.Extensions.PersistedQuery = persistedQuery{Version: 1, Sha256Hash: queryHash(original.Query)}
+
+	body, err := json.Marshal(persisted)
+	if err != nil {
+		return nil, err
+	}
+	hashOnly := req.Clone(req.Context())
+	setBody(hashOnly, body)
+
+	resp, err := p.rt.RoundTrip(hashOnly)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !persistedQueryNotFound(respBody) {
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		return resp, nil
+	}
+
+	// the server doesn't know the hash yet, send the document so it's registered
+	persisted.Query = original.Query
+	body, err = json.Marshal(persisted)
+	if err != nil {
+		return nil, err
+	}
+	setBody(req, body)
+	return p.rt.RoundTrip(req)
+}
+
+// persistedQueryNotFound tells whether the response body is the error returned by servers not knowing the hash of
+// a persisted query.
+func persistedQueryNotFound(body []byte) bool {
+	var out struct {
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return false
+	}
+	for _, e := range out.Errors {
+		if e.Message == "PersistedQueryNotFound" || e.Extensions.Code == "PERSISTED_QUERY_NOT_FOUND" {
+			return true
+		}
+	}
+	return false
+}
+
+func queryHash(document string) string {
+	sum := sha256.Sum256([]byte(document))
+	return hex.EncodeToString(sum[:])
+}
+
+// CollectedQuery is a document sent by a client using WithQueryCollector
+type CollectedQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// QueryCollector records the documents sent by the clients using it, so they can be added to HGE's allow-list.
+// A collector can be shared by several clients.
+type QueryCollector struct {
+	mu      sync.Mutex
+	queries map[string]string
+}
+
+func NewQueryCollector() *QueryCollector {
+	return &QueryCollector{queries: map[string]string{}}
+}
+
+func (c *QueryCollector) add(operationName, document string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries[document] = operationName
+}
+
+// Queries returns the collected documents sorted by name. Names are the operation names, suffixed with the first
+// characters of the document hash when several documents share the same operation name.
+func (c *QueryCollector) Queries() []CollectedQuery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	documentsByName := map[string][]string{}
+	for document, name := range c.queries {
+		if name == "" {
+			name = "anonymous"
+		}
+		documentsByName[name] = append(documentsByName[name], document)
+	}
+
+	queries := make([]CollectedQuery, 0, len(c.queries))
+	for name, documents := range documentsByName {
+		for _, document := range documents {
+			uniqueName := name
+			if len(documents) > 1 || name == "anonymous" {
+				uniqueName = name + "_" + queryHash(document)[:8]
+			}
+			queries = append(queries, CollectedQuery{Name: uniqueName, Query: document})
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Name < queries[j].Name
+	})
+	return queries
+}
+
+// WriteAllowlist writes a metadata API request that creates a query collection with the given name, holding the
+// collected documents, and adds it to HGE's allow-list. The output can be POSTed to /v1/metadata as is.
+func (c *QueryCollector) WriteAllowlist(w io.Writer, collection string) error {
+	type arg struct {
+		Type string `json:"type"`
+		Args any    `json:"args"`
+	}
+
+	var create arg
+	create.Type = "create_query_collection"
+	create.Args = map[string]any{
+		"name": collection,
+		"definition": map[string]any{
+			"queries": c.Queries(),
+		},
+	}
+
+	request := arg{
+		Type: "bulk",
+		Args: []arg{
+			create,
+			{Type: "add_collection_to_allowlist", Args: map[string]any{"collection": collection}},
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(request)
+}
+
+type collectorRoundTripper struct {
+	collector *QueryCollector
+	rt        http.RoundTripper
+}
+
+func (c collectorRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p, _, err := readPayload(req); err == nil && strings.TrimSpace(p.Query) != "" {
+		c.collector.add(p.OperationName, p.Query)
+	}
+	return c.rt.RoundTrip(req)
+}
@@ -0,0 +1,141 @@
+package gql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistedQueries(t *testing.T) {
+	known := map[string]bool{}
+	var sent []persistedPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p persistedPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		sent = append(sent, p)
+
+		hash := p.Extensions.PersistedQuery.Sha256Hash
+		if p.Query == "" && !known[hash] {
+			_, _ = w.Write([]byte(`{"errors":[{"message":"PersistedQueryNotFound"}]}`))
+			return
+		}
+		known[hash] = true
+		_, _ = w.Write([]byte(`{"data":{"thing":{"field":1}}}`))
+	}))
+	defer ts.Close()
+
+	cl := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithPersistedQueries())
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Equal(t, 1, query.Thing.Field)
+	assert.Len(t, sent, 2)
+	assert.Empty(t, sent[0].Query)
+	assert.Equal(t, "query GetThing{thing{field}}", sent[1].Query)
+	assert.Equal(t, queryHash(sent[1].Query), sent[1].Extensions.PersistedQuery.Sha256Hash)
+
+	query.Thing.Field = 0
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Equal(t, 1, query.Thing.Field)
+	assert.Len(t, sent, 3, "known documents should only be sent by hash")
+	assert.Empty(t, sent[2].Query)
+}
+
+func TestPersistedQueriesDeduplication(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"data":{"thing":{"field":1}}}`))
+	}))
+	defer ts.Close()
+
+	cl := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithPersistedQueries(), WithDeduplication())
+
+	type query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	run := func(do func(q *query) error) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, do(&query{}))
+			}()
+		}
+		wg.Wait()
+	}
+
+	run(func(q *query) error {
+		return cl.NamedQuery(context.TODO(), "GetThing", q, nil)
+	})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the deduplication only sees the hash of the document, but still knows it's a mutation
+	atomic.StoreInt32(&calls, 0)
+	run(func(q *query) error {
+		return cl.NamedMutate(context.TODO(), "UpdateThing", q, nil)
+	})
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls), "mutations should never be deduplicated")
+}
+
+func TestQueryCollector(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"thing":{"field":1}}}`))
+	}))
+	defer ts.Close()
+
+	collector := NewQueryCollector()
+	cl := NewAdminClientFromHost(ts.URL, "admin-secret", "test-client", WithQueryCollector(collector))
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	_ = cl.NamedQuery(context.TODO(), "GetThing", &query, nil)
+	_ = cl.NamedQuery(context.TODO(), "GetThing", &query, nil)
+	_ = cl.ForceAdmin().NamedMutate(context.TODO(), "UpdateThing", &query, nil)
+
+	assert.Equal(t, []CollectedQuery{
+		{Name: "GetThing", Query: "query GetThing{thing{field}}"},
+		{Name: "UpdateThing", Query: "mutation UpdateThing{thing{field}}"},
+	}, collector.Queries())
+
+	var buf bytes.Buffer
+	assert.NoError(t, collector.WriteAllowlist(&buf, "allowed-queries"))
+	assert.JSONEq(t, `{
+		"type": "bulk",
+		"args": [
+			{
+				"type": "create_query_collection",
+				"args": {
+					"name": "allowed-queries",
+					"definition": {
+						"queries": [
+							{"name": "GetThing", "query": "query GetThing{thing{field}}"},
+							{"name": "UpdateThing", "query": "mutation UpdateThing{thing{field}}"}
+						]
+					}
+				}
+			},
+			{"type": "add_collection_to_allowlist", "args": {"collection": "allowed-queries"}}
+		]
+	}`, buf.String())
+}
@@ -0,0 +1,328 @@
+package gql
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RoutingPolicy decides which read replica serves each query
+type RoutingPolicy int
+
+const (
+	// RoundRobin spreads the queries evenly among the healthy replicas
+	RoundRobin RoutingPolicy = iota
+	// LeastLatency sends the queries to the healthy replica that has been answering the fastest
+	LeastLatency
+)
+
+type poolOptions struct {
+	policy         RoutingPolicy
+	healthInterval time.Duration
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	healthClient   *http.Client
+}
+
+var defaultPoolOptions = poolOptions{
+	policy:         RoundRobin,
+	healthInterval: 10 * time.Second,
+	minBackoff:     time.Second,
+	maxBackoff:     time.Minute,
+	healthClient:   &http.Client{Timeout: 5 * time.Second},
+}
+
+type PoolOption func(*poolOptions)
+
+// WithRoutingPolicy sets how queries are spread among the replicas, RoundRobin by default
+func WithRoutingPolicy(policy RoutingPolicy) PoolOption {
+	return func(opts *poolOptions) {
+		opts.policy = policy
+	}
+}
+
+// WithHealthCheckInterval sets how often the endpoints' /healthz is checked, every 10 seconds by default
+func WithHealthCheckInterval(interval time.Duration) PoolOption {
+	return func(opts *poolOptions) {
+		opts.healthInterval = interval
+	}
+}
+
+// WithEjectionBackoff sets for how long a failing endpoint is ejected. The time doubles, from min up to max, every
+// time the endpoint fails again before recovering.
+func WithEjectionBackoff(min, max time.Duration) PoolOption {
+	return func(opts *poolOptions) {
+		opts.minBackoff = min
+		opts.maxBackoff = max
+	}
+}
+
+type endpoint struct {
+	url *url.URL
+	// healthChecked endpoints are only re-admitted by passing a health check once their ejection time is over
+	healthChecked bool
+
+	mu           sync.Mutex
+	ejected      bool
+	ejectedUntil time.Time
+	backoff      time.Duration
+	latency      time.Duration
+}
+
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.ejected || (!e.healthChecked && !now.Before(e.ejectedUntil))
+}
+
+// due tells whether the endpoint must be health checked: it's not ejected, or its ejection time is over
+func (e *endpoint) due(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.ejected || !now.Before(e.ejectedUntil)
+}
+
+// EndpointPool routes the requests of the clients using it between a primary HGE instance and its read replicas:
+// mutations always go to the primary, while queries are spread among the healthy replicas, falling back to the
+// primary when none is available. Endpoints failing requests or health checks are ejected for an exponential backoff,
+// and re-admitted once they pass a health check after it.
+//
+// As routing happens in the transport, actor headers are preserved and clients derived with AsAdmin use the same
+// pool:
+//
+//	pool, _ := gql.NewEndpointPool(primary, []string{replica1, replica2})
+//	defer pool.Close()
+//	client := gql.NewPromotableClient(pool.Primary(), secret, actor, "my-service", gql.WithEndpointPool(pool))
+type EndpointPool struct {
+	opts     poolOptions
+	primary  *endpoint
+	replicas []*endpoint
+	next     atomic.Uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewEndpointPool creates a pool for the given graphql endpoints, and starts health checking them until Close is
+// called.
+func NewEndpointPool(primary string, replicas []string, options ...PoolOption) (*EndpointPool, error) {
+	opts := defaultPoolOptions
+	for _, apply := range options {
+		apply(&opts)
+	}
+
+	p := &EndpointPool{opts: opts, stop: make(chan struct{})}
+	var err error
+	if p.primary, err = newEndpoint(primary, opts.healthInterval > 0); err != nil {
+		return nil, err
+	}
+	for _, replica := range replicas {
+		e, err := newEndpoint(replica, opts.healthInterval > 0)
+		if err != nil {
+			return nil, err
+		}
+		p.replicas = append(p.replicas, e)
+	}
+
+	if opts.healthInterval > 0 {
+		go p.healthCheck()
+	}
+	return p, nil
+}
+
+func newEndpoint(rawURL string, healthChecked bool) (*endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", rawURL, err)
+	}
+	return &endpoint{url: u, healthChecked: healthChecked}, nil
+}
+
+// Primary returns the primary endpoint
+func (p *EndpointPool) Primary() string {
+	return p.primary.url.String()
+}
+
+// Close stops health checking the endpoints
+func (p *EndpointPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// WithEndpointPool routes the client requests through the given pool, instead of sending them to the endpoint the
+// client was created with.
+func WithEndpointPool(pool *EndpointPool) Option {
+	return func(opts *options) {
+		opts.pool = pool
+	}
+}
+
+// candidates returns the endpoints to try, in order, for a query
+func (p *EndpointPool) candidates() []*endpoint {
+	now := time.Now()
+	var available []*endpoint
+	for _, e := range p.replicas {
+		if e.available(now) {
+			available = append(available, e)
+		}
+	}
+
+	switch {
+	case len(available) == 0:
+	case p.opts.policy == LeastLatency:
+		best := 0
+		for i, e := range available {
+			if e.currentLatency() < available[best].currentLatency() {
+				best = i
+			}
+		}
+		available[0], available[best] = available[best], available[0]
+	default:
+		start := int(p.next.Add(1)-1) % len(available)
+		available = append(available[start:], available[:start]...)
+	}
+
+	return append(available, p.primary)
+}
+
+func (e *endpoint) currentLatency() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.latency
+}
+
+func (p *EndpointPool) succeeded(e *endpoint, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.backoff = 0
+	e.ejected = false
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		// exponentially weighted moving average
+		e.latency = (4*e.latency + latency) / 5
+	}
+}
+
+func (p *EndpointPool) failed(e *endpoint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.backoff == 0 {
+		e.backoff = p.opts.minBackoff
+	} else if e.backoff < p.opts.maxBackoff {
+		e.backoff = min(2*e.backoff, p.opts.maxBackoff)
+	}
+	e.ejected = true
+	e.ejectedUntil = time.Now().Add(e.backoff)
+}
+
+// healthCheck checks every endpoint that is not ejected, or whose ejection time is over, so healthy endpoints
+// failing their check get ejected, for longer if they were already, and ejected endpoints passing it get re-admitted.
+func (p *EndpointPool) healthCheck() {
+	ticker := time.NewTicker(p.opts.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for _, e := range append([]*endpoint{p.primary}, p.replicas...) {
+			if !e.due(now) {
+				continue
+			}
+			if p.healthy(e) {
+				e.mu.Lock()
+				e.backoff = 0
+				e.ejected = false
+				e.mu.Unlock()
+			} else {
+				p.failed(e)
+			}
+		}
+	}
+}
+
+func (p *EndpointPool) healthy(e *endpoint) bool {
+	healthz := *e.url
+	healthz.Path = healthzPath(e.url.Path)
+	healthz.RawPath = ""
+	healthz.RawQuery = ""
+
+	resp, err := p.opts.healthClient.Get(healthz.String())
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// healthzPath returns the path of the /healthz endpoint of the HGE serving the given graphql path, keeping the prefix
+// HGE might be served under: /hasura/v1/graphql is checked with /hasura/healthz
+func healthzPath(graphqlPath string) string {
+	if i := strings.LastIndex(graphqlPath, "/v1"); i >= 0 {
+		return graphqlPath[:i] + "/healthz"
+	}
+	return "/healthz"
+}
+
+type poolRoundTripper struct {
+	pool *EndpointPool
+	rt   http.RoundTripper
+}
+
+// RoundTrip sends mutations to the primary, and queries to the first candidate endpoint that answers. Mutations are
+// never retried, as they might have been applied even if they failed.
+func (p poolRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	payload, body, err := readPayload(req)
+	if err != nil || requestIsMutation(req, payload) {
+		return p.send(req, p.pool.primary, body)
+	}
+
+	var errs []error
+	for _, e := range p.pool.candidates() {
+		resp, err := p.send(req, e, body)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+
+		if err == nil {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("%s: %s", e.url.Host, resp.Status)
+		}
+		errs = append(errs, err)
+		if req.Context().Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (p poolRoundTripper) send(req *http.Request, e *endpoint, body []byte) (*http.Response, error) {
+	routed := req.Clone(req.Context())
+	routed.URL = e.url
+	routed.Host = ""
+	setBody(routed, body)
+
+	start := time.Now()
+	resp, err := p.rt.RoundTrip(routed)
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		// the caller giving up, or its timeout, says nothing about the endpoint
+		if req.Context().Err() == nil {
+			p.pool.failed(e)
+		}
+		return resp, err
+	}
+	p.pool.succeeded(e, time.Since(start))
+	return resp, nil
+}
@@ -0,0 +1,136 @@
+package gql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointPool(t *testing.T) {
+	primary, replica1, replica2 := gqltest.NewServer(t), gqltest.NewServer(t), gqltest.NewServer(t)
+	for _, srv := range []*gqltest.Server{primary, replica1, replica2} {
+		srv.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+	}
+
+	pool, err := NewEndpointPool(primary.Endpoint(), []string{replica1.Endpoint(), replica2.Endpoint()},
+		WithHealthCheckInterval(0), WithEjectionBackoff(time.Hour, time.Hour))
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	sampleUUID := uuid.New()
+	cl := NewPromotableClient(pool.Primary(), "admin-secret", NewUserActor(&sampleUUID, "foo@bar.baz"), "test-client", WithEndpointPool(pool))
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	}
+	assert.NoError(t, cl.ForceAdmin().NamedMutate(context.TODO(), "UpdateThing", &query, nil))
+
+	assert.Len(t, replica1.Requests(), 2)
+	assert.Len(t, replica2.Requests(), 2)
+	replica1.AssertCalledAs(t, "GetThing", RoleUser)
+	primary.AssertNotCalled(t, "GetThing")
+	primary.AssertCalledAs(t, "UpdateThing", RoleAdmin)
+
+	// a failing replica is ejected, and its queries retried on the other one
+	replica1.OnDocument("thing").WithStatus(http.StatusServiceUnavailable)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	}
+	assert.Len(t, replica1.Requests(), 3)
+	assert.Len(t, replica2.Requests(), 6)
+
+	// callers timing out don't eject the replica
+	replica2.OnDocument("thing").Returns(`{"thing":{"field":1}}`).WithLatency(50 * time.Millisecond)
+	ctx := WithCallOptions(context.TODO(), CallTimeout(10*time.Millisecond))
+	assert.Error(t, cl.NamedQuery(ctx, "GetThing", &query, nil))
+	assert.True(t, pool.replicas[1].available(time.Now()))
+
+	// with no replica available, queries fall back to the primary
+	replica2.Close()
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	primary.AssertCalled(t, "GetThing")
+}
+
+func TestEndpointPoolPersistedQueries(t *testing.T) {
+	var primaryCalls, replicaCalls int32
+	server := func(calls *int32) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(calls, 1)
+			_, _ = w.Write([]byte(`{"data":{"thing":{"field":1}}}`))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	primary, replica := server(&primaryCalls), server(&replicaCalls)
+
+	pool, err := NewEndpointPool(primary.URL+"/v1/graphql", []string{replica.URL + "/v1/graphql"}, WithHealthCheckInterval(0))
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	// the pool only sees the hash of the documents, but still sends mutations to the primary
+	cl := NewAdminClient(pool.Primary(), "admin-secret", "test-client", WithEndpointPool(pool), WithPersistedQueries(), WithDeduplication())
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, cl.NamedMutate(context.TODO(), "UpdateThing", &query, nil))
+	}
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&primaryCalls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&replicaCalls))
+}
+
+func TestEndpointPoolReadmission(t *testing.T) {
+	primary, replica := gqltest.NewServer(t), gqltest.NewServer(t)
+	primary.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+	replica.OnDocument("thing").WithStatus(http.StatusServiceUnavailable)
+	replica.SetHealthy(false)
+
+	// HGE served under a path prefix is checked on the prefixed /healthz
+	pool, err := NewEndpointPool(primary.Endpoint(), []string{replica.URL + "/hasura/v1/graphql"},
+		WithHealthCheckInterval(5*time.Millisecond), WithEjectionBackoff(10*time.Millisecond, 10*time.Millisecond))
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	cl := NewAdminClient(pool.Primary(), "admin-secret", "test-client", WithEndpointPool(pool))
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Equal(t, 1, query.Thing.Field)
+
+	// the replica stays ejected past its backoff while its health check fails
+	assert.Never(t, func() bool {
+		return pool.replicas[0].available(time.Now())
+	}, 100*time.Millisecond, 5*time.Millisecond)
+
+	replica.OnDocument("thing").Returns(`{"thing":{"field":2}}`)
+	replica.SetHealthy(true)
+	assert.Eventually(t, func() bool {
+		return pool.replicas[0].available(time.Now())
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Equal(t, 2, query.Thing.Field)
+}
+
+func TestHealthzPath(t *testing.T) {
+	assert.Equal(t, "/healthz", healthzPath("/v1/graphql"))
+	assert.Equal(t, "/hasura/healthz", healthzPath("/hasura/v1/graphql"))
+	assert.Equal(t, "/healthz", healthzPath(""))
+}
@@ -0,0 +1,219 @@
+package gql
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	gogql "github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/util"
+)
+
+var (
+	// ErrNoActor is returned by ActorAwareClientFrom, and the client of ClientFrom, when the context has no actor
+	ErrNoActor = errors.New("no actor in the context")
+	// ErrNoClientProvider is returned by ActorAwareClientFrom, and the client of ClientFrom, when the context has no
+	// ClientProvider
+	ErrNoClientProvider = errors.New("no client provider in the context")
+)
+
+// ContextWithActor returns a context carrying the given actor
+func ContextWithActor(ctx context.Context, actor *Actor) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFromContext returns the actor set with ContextWithActor, if any
+func ActorFromContext(ctx context.Context) (*Actor, bool) {
+	actor, ok := ctx.Value(actorKey).(*Actor)
+	return actor, ok && actor != nil
+}
+
+// ContextWithClientProvider returns a context carrying the given provider, used by ClientFrom
+func ContextWithClientProvider(ctx context.Context, provider *ClientProvider) context.Context {
+	return context.WithValue(ctx, providerKey, provider)
+}
+
+// ActorExtractor returns the actor making an incoming request, or nil for anonymous requests
+type ActorExtractor func(r *http.Request) (*Actor, error)
+
+// ActorFromSessionHeaders extracts the actor from the x-hasura-* session headers of the request, as HGE forwards
+// them to Actions and Remote Schemas. The whole session, project lists included, is kept in the actor's session
+// variables so it's forwarded as is by the actor's clients. The headers must come from HGE, or another trusted proxy:
+// they're not authenticated.
+func ActorFromSessionHeaders(r *http.Request) (*Actor, error) {
+	if r.Header.Get(util.XHasuraRole) == "" {
+		return nil, nil
+	}
+	sessionVariables := util.StringMap{}
+	for name := range r.Header {
+		sessionVariables[name] = r.Header.Get(name)
+	}
+	return sessionActor(NewAccessFromSessionVariables(sessionVariables)), nil
+}
+
+// ActorMiddleware sets the actor of every incoming request in its context, along with the provider when not nil, so
+// handlers can use ActorFromContext and ClientFrom. Requests whose actor can't be extracted are rejected with a 401.
+func ActorMiddleware(extract ActorExtractor, provider *ClientProvider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor, err := extract(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			ctx := r.Context()
+			if actor != nil {
+				ctx = ContextWithActor(ctx, actor)
+			}
+			if provider != nil {
+				ctx = ContextWithClientProvider(ctx, provider)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientProvider creates clients acting as the actors of incoming requests, caching them per actor so they can be
+// reused across requests.
+type ClientProvider struct {
+	newClient  func(actor *Actor) *ActorAwareClient
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     *list.List
+}
+
+type providedClient struct {
+	key    string
+	client *ActorAwareClient
+}
+
+// NewClientProvider creates a provider of clients created with NewClient, caching the clients of up to maxClients
+// actors
+func NewClientProvider(endpoint, adminSecret, clientName string, maxClients int, options ...Option) *ClientProvider {
+	return &ClientProvider{
+		newClient: func(actor *Actor) *ActorAwareClient {
+			return NewClient(endpoint, adminSecret, actor, clientName, options...)
+		},
+		maxClients: maxClients,
+		clients:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// ClientFor returns a client acting as the given actor, creating it unless a client for an equal actor is cached
+func (p *ClientProvider) ClientFor(actor *Actor) *ActorAwareClient {
+	key := actorKeyOf(actor)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.clients[key]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*providedClient).client
+	}
+
+	client := p.newClient(actor)
+	p.clients[key] = p.lru.PushFront(&providedClient{key: key, client: client})
+	for p.maxClients > 0 && p.lru.Len() > p.maxClients {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.clients, oldest.Value.(*providedClient).key)
+	}
+	return client
+}
+
+// actorKeyOf identifies the actors whose requests are sent with the same headers
+func actorKeyOf(actor *Actor) string {
+	var b strings.Builder
+	b.WriteString(actor.Role + "\n")
+	if actor.UserID != nil {
+		b.WriteString(actor.UserID.String())
+	}
+	b.WriteString("\n" + actor.Email + "\n" + strconv.FormatBool(actor.UsesSAML) + "\n")
+	b.WriteString(strings.Join(actor.AllowedRoles, ",") + "\n")
+
+	names := make([]string, 0, len(actor.SessionVariables))
+	for name := range actor.SessionVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(name + "=" + actor.SessionVariables[name] + "\n")
+	}
+	return b.String()
+}
+
+// ClientFrom returns a client acting as the actor of the context, from the ClientProvider of the context. Without
+// either, the returned client fails every request with ErrNoActor or ErrNoClientProvider. Use ActorAwareClientFrom to
+// read the actor of the client, or derive clients from it.
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		err := gql.ClientFrom(r.Context()).NamedQuery(r.Context(), "GetProjects", &q, nil)
+//		...
+//	}
+func ClientFrom(ctx context.Context) Client {
+	client, err := ActorAwareClientFrom(ctx)
+	if err != nil {
+		return errClient{err: err}
+	}
+	return client
+}
+
+// ActorAwareClientFrom returns a client acting as the actor of the context, from the ClientProvider of the context,
+// or ErrNoActor or ErrNoClientProvider without either.
+//
+//	client, err := gql.ActorAwareClientFrom(r.Context())
+//	if err != nil {
+//		...
+//	}
+//	admin, err := client.Elevate("export the user's projects")
+func ActorAwareClientFrom(ctx context.Context) (*ActorAwareClient, error) {
+	provider, _ := ctx.Value(providerKey).(*ClientProvider)
+	actor, ok := ActorFromContext(ctx)
+	switch {
+	case provider == nil:
+		return nil, ErrNoClientProvider
+	case !ok:
+		return nil, ErrNoActor
+	}
+	return provider.ClientFor(actor), nil
+}
+
+// errClient fails every request with the given error
+type errClient struct {
+	err error
+}
+
+func (c errClient) Query(ctx context.Context, q interface{}, variables map[string]interface{}, options ...gogql.Option) error {
+	return c.err
+}
+
+func (c errClient) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...gogql.Option) error {
+	return c.err
+}
+
+func (c errClient) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...gogql.Option) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c errClient) Mutate(ctx context.Context, m interface{}, variables map[string]interface{}, options ...gogql.Option) error {
+	return c.err
+}
+
+func (c errClient) NamedMutate(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...gogql.Option) error {
+	return c.err
+}
+
+func (c errClient) NamedMutateRaw(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...gogql.Option) ([]byte, error) {
+	return nil, c.err
+}
+
+// assert that errClient implements Client
+var _ Client = errClient{}
@@ -0,0 +1,96 @@
+package gql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientFrom(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+
+	provider := NewClientProvider(ts.Endpoint(), "admin-secret", "test-client", 2)
+	handler := ActorMiddleware(ActorFromSessionHeaders, provider)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Thing struct {
+				Field int `graphql:"field"`
+			} `graphql:"thing"`
+		}
+		if err := ClientFrom(r.Context()).NamedQuery(r.Context(), "GetThing", &query, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	userID, projectID := uuid.New(), uuid.New()
+	req := httptest.NewRequest(http.MethodPost, "/action", nil)
+	req.Header.Set("X-Hasura-Role", RoleUser)
+	req.Header.Set("X-Hasura-User-Id", userID.String())
+	req.Header.Set("X-Hasura-User-Email", "foo@bar.baz")
+	req.Header.Set("X-Hasura-Tenant-Id", "acme")
+	req.Header.Set("X-Hasura-Allowed-Project-Ids", "{"+projectID.String()+"}")
+	req.Header.Set("X-Hasura-Allowed-Metrics-Project-Ids", "{}")
+	req.Header.Set("X-Hasura-Admin-Secret", "forged")
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/action", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrNoActor.Error())
+
+	requests := ts.RequestsFor("GetThing")
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, userID.String(), requests[0].UserID)
+		assert.Equal(t, "acme", requests[0].Headers.Get("X-Hasura-Tenant-Id"))
+		assert.Equal(t, "{"+projectID.String()+"}", requests[0].Headers.Get("X-Hasura-Allowed-Project-Ids"))
+		assert.Equal(t, "{}", requests[0].Headers.Get("X-Hasura-Allowed-Metrics-Project-Ids"))
+		assert.Empty(t, requests[0].Headers.Values("X-Hasura-Admin-Project-Ids"))
+		assert.Equal(t, "admin-secret", requests[0].Headers.Get(XHasuraAdminSecret))
+	}
+	assert.Equal(t, 1, provider.lru.Len())
+}
+
+func TestClientProvider(t *testing.T) {
+	provider := NewClientProvider("http://localhost/v1/graphql", "admin-secret", "test-client", 2)
+	userID, otherID := uuid.New(), uuid.New()
+
+	user := provider.ClientFor(NewUserActor(&userID, "foo@bar.baz"))
+	assert.Same(t, user, provider.ClientFor(NewUserActor(&userID, "foo@bar.baz")))
+	assert.NotSame(t, user, provider.ClientFor(NewUserActor(&userID, "foo@bar.baz").SetSessionVariable("tenant", "acme")))
+
+	// the least recently used client is evicted
+	provider.ClientFor(NewUserActor(&otherID, "other@bar.baz"))
+	assert.NotSame(t, user, provider.ClientFor(NewUserActor(&userID, "foo@bar.baz")))
+
+	ctx := ContextWithActor(context.Background(), NewUserActor(&userID, "foo@bar.baz"))
+	actor, ok := ActorFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, userID, *actor.UserID)
+	_, ok = ActorFromContext(context.Background())
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, ClientFrom(ctx).Query(ctx, nil, nil), ErrNoClientProvider)
+	ctx = ContextWithClientProvider(context.Background(), provider)
+	assert.ErrorIs(t, ClientFrom(ctx).Mutate(ctx, nil, nil), ErrNoActor)
+	_, err := ActorAwareClientFrom(ctx)
+	assert.ErrorIs(t, err, ErrNoActor)
+
+	// the actor aware client can be used to derive other clients
+	ctx = ContextWithActor(ctx, NewUserActor(&userID, "foo@bar.baz"))
+	client, err := ActorAwareClientFrom(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, *client.Actor.UserID)
+	assert.Same(t, client, ClientFrom(ctx))
+	reader, err := client.WithRole(RoleUser)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleUser, reader.Actor.Role)
+}
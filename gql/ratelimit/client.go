@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/hasura/hge-go-gql-client/gql"
+)
+
+// Client is a gql.Client whose requests go through the limits of a Limiter
+type Client struct {
+	cl      *gql.ActorAwareClient
+	limiter *Limiter
+	actorID string
+}
+
+// Wrap wraps the given client, limiting its requests with the limits of its actor
+func (l *Limiter) Wrap(cl *gql.ActorAwareClient) *Client {
+	c := &Client{cl: cl, limiter: l}
+	if cl.Actor != nil && cl.Actor.UserID != nil {
+		c.actorID = cl.Actor.UserID.String()
+	}
+	return c
+}
+
+func (c *Client) do(ctx context.Context, operationName string, request func() error) error {
+	release, err := c.limiter.Acquire(ctx, operationName, c.actorID)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return request()
+}
+
+func (c *Client) Query(ctx context.Context, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, "", func() error {
+		return c.cl.Query(ctx, q, variables, options...)
+	})
+}
+
+func (c *Client) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, name, func() error {
+		return c.cl.NamedQuery(ctx, name, q, variables, options...)
+	})
+}
+
+func (c *Client) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	var data []byte
+	err := c.do(ctx, name, func() (err error) {
+		data, err = c.cl.NamedQueryRaw(ctx, name, q, variables, options...)
+		return err
+	})
+	return data, err
+}
+
+func (c *Client) Mutate(ctx context.Context, m interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, "", func() error {
+		return c.cl.Mutate(ctx, m, variables, options...)
+	})
+}
+
+func (c *Client) NamedMutate(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...graphql.Option) error {
+	return c.do(ctx, name, func() error {
+		return c.cl.NamedMutate(ctx, name, m, variables, options...)
+	})
+}
+
+func (c *Client) NamedMutateRaw(ctx context.Context, name string, m interface{}, variables map[string]interface{}, options ...graphql.Option) ([]byte, error) {
+	var data []byte
+	err := c.do(ctx, name, func() (err error) {
+		data, err = c.cl.NamedMutateRaw(ctx, name, m, variables, options...)
+		return err
+	})
+	return data, err
+}
+
+// assert that *Client implements gql.Client
+var _ gql.Client = &Client{}
@@ -0,0 +1,411 @@
+// Package ratelimit limits the rate and concurrency of the requests sent to HGE, globally, per operation name and
+// per actor, so a single noisy tenant can't exhaust HGE's connection pool.
+//
+// A Limiter is shared by the clients of all the actors, each wrapped with Wrap:
+//
+//	limiter := ratelimit.NewLimiter(
+//		ratelimit.WithRate(200, 50),
+//		ratelimit.WithActorRate(10, 5),
+//		ratelimit.WithActorMaxInFlight(4),
+//	)
+//	client := limiter.Wrap(gql.NewClient(endpoint, secret, actor, "my-service"))
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrLimited matches, with errors.Is, every *LimitError
+var ErrLimited = errors.New("request limited")
+
+// Scope is the scope of a limit
+type Scope string
+
+const (
+	ScopeGlobal    Scope = "global"
+	ScopeOperation Scope = "operation"
+	ScopeActor     Scope = "actor"
+)
+
+// LimitError is returned when a request isn't sent because of a limit: right away with WithFailFast, or when the
+// context ends, or would end, before the request can be sent.
+type LimitError struct {
+	Scope Scope
+	// Key is the operation name or the actor user id the limit applies to, empty for global limits
+	Key string
+	// InFlight is true for limits on the requests in flight, and false for rate limits
+	InFlight bool
+	// RetryAfter is how long until the rate limit would allow the request, zero for limits on requests in flight
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	kind := "rate"
+	if e.InFlight {
+		kind = "in-flight"
+	}
+	if e.Key == "" {
+		return fmt.Sprintf("%s %s limit reached", e.Scope, kind)
+	}
+	return fmt.Sprintf("%s %s limit reached for %s", e.Scope, kind, e.Key)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+// Rate is a token bucket configuration: PerSecond requests on average, with bursts of up to Burst requests
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+type options struct {
+	rate           *Rate
+	operationRates map[string]Rate
+	actorRate      *Rate
+
+	maxInFlight          int
+	operationMaxInFlight map[string]int
+	actorMaxInFlight     int
+
+	failFast bool
+}
+
+func newOptions() options {
+	return options{
+		operationRates:       map[string]Rate{},
+		operationMaxInFlight: map[string]int{},
+	}
+}
+
+type Option func(*options)
+
+// WithRate limits the rate of all the requests
+func WithRate(perSecond float64, burst int) Option {
+	return func(opts *options) {
+		opts.rate = &Rate{PerSecond: perSecond, Burst: burst}
+	}
+}
+
+// WithOperationRate limits the rate of the requests for the given operation name
+func WithOperationRate(operationName string, perSecond float64, burst int) Option {
+	return func(opts *options) {
+		opts.operationRates[operationName] = Rate{PerSecond: perSecond, Burst: burst}
+	}
+}
+
+// WithActorRate limits the rate of the requests of each actor, by user id. Actors without a user id aren't limited.
+func WithActorRate(perSecond float64, burst int) Option {
+	return func(opts *options) {
+		opts.actorRate = &Rate{PerSecond: perSecond, Burst: burst}
+	}
+}
+
+// WithMaxInFlight limits how many requests can be in flight at once
+func WithMaxInFlight(n int) Option {
+	return func(opts *options) {
+		opts.maxInFlight = n
+	}
+}
+
+// WithOperationMaxInFlight limits how many requests for the given operation name can be in flight at once
+func WithOperationMaxInFlight(operationName string, n int) Option {
+	return func(opts *options) {
+		opts.operationMaxInFlight[operationName] = n
+	}
+}
+
+// WithActorMaxInFlight limits how many requests of each actor, by user id, can be in flight at once. Actors without
+// a user id aren't limited.
+func WithActorMaxInFlight(n int) Option {
+	return func(opts *options) {
+		opts.actorMaxInFlight = n
+	}
+}
+
+// WithFailFast fails limited requests right away with a *LimitError. By default they wait until they can be sent, or
+// until their context ends.
+func WithFailFast() Option {
+	return func(opts *options) {
+		opts.failFast = true
+	}
+}
+
+// bucket is a token bucket. Its tokens go negative when waiting requests reserve tokens ahead of time.
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate Rate, now time.Time) *bucket {
+	return &bucket{rate: rate, tokens: float64(rate.Burst), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.rate.Burst), b.tokens+elapsed.Seconds()*b.rate.PerSecond)
+		b.last = now
+	}
+}
+
+// wait returns how long until a token is available
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.rate.PerSecond <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - b.tokens) / b.rate.PerSecond * float64(time.Second))
+}
+
+type limit struct {
+	scope  Scope
+	key    string
+	bucket *bucket
+	slots  chan struct{}
+	// users counts the requests waiting for, or holding, the limit
+	users int
+}
+
+// idle tells whether the limit is in the same state it would be created in, so it can be dropped. It must be called
+// holding the limiter's lock.
+func (l *limit) idle(now time.Time) bool {
+	if l.users > 0 {
+		return false
+	}
+	if l.bucket != nil {
+		l.bucket.refill(now)
+		return l.bucket.tokens >= float64(l.bucket.rate.Burst)
+	}
+	return true
+}
+
+func (l *limit) rateError(retryAfter time.Duration) error {
+	return &LimitError{Scope: l.scope, Key: l.key, RetryAfter: retryAfter}
+}
+
+func (l *limit) inFlightError() error {
+	return &LimitError{Scope: l.scope, Key: l.key, InFlight: true}
+}
+
+// sweepInterval is how often the limits of idle actors are dropped, so the limiter doesn't grow with every actor it
+// has ever seen
+const sweepInterval = time.Minute
+
+// Limiter holds the state of the limits, shared by all the clients it wraps
+type Limiter struct {
+	opts options
+	now  func() time.Time
+
+	mu        sync.Mutex
+	global    *limit
+	ops       map[string]*limit
+	actors    map[string]*limit
+	lastSweep time.Time
+}
+
+// NewLimiter creates a limiter with the given limits. Without any, requests aren't limited.
+func NewLimiter(options ...Option) *Limiter {
+	opts := newOptions()
+	for _, apply := range options {
+		apply(&opts)
+	}
+
+	l := &Limiter{
+		opts:   opts,
+		now:    time.Now,
+		ops:    map[string]*limit{},
+		actors: map[string]*limit{},
+	}
+	l.global = l.newLimit(ScopeGlobal, "", opts.rate, opts.maxInFlight)
+	// only the operations with limits get one, so the map is bounded by the options
+	for operationName := range opts.operationRates {
+		l.ops[operationName] = l.newOperationLimit(operationName)
+	}
+	for operationName := range opts.operationMaxInFlight {
+		l.ops[operationName] = l.newOperationLimit(operationName)
+	}
+	return l
+}
+
+func (l *Limiter) newOperationLimit(operationName string) *limit {
+	var rate *Rate
+	if r, ok := l.opts.operationRates[operationName]; ok {
+		rate = &r
+	}
+	return l.newLimit(ScopeOperation, operationName, rate, l.opts.operationMaxInFlight[operationName])
+}
+
+func (l *Limiter) newLimit(scope Scope, key string, rate *Rate, maxInFlight int) *limit {
+	lim := &limit{scope: scope, key: key}
+	if rate != nil {
+		lim.bucket = newBucket(*rate, l.now())
+	}
+	if maxInFlight > 0 {
+		lim.slots = make(chan struct{}, maxInFlight)
+	}
+	return lim
+}
+
+// limits returns the limits applying to a request, creating the actor's on first use, and counts the request as a
+// user of each. leave must be called once the request is done with them.
+func (l *Limiter) limits(operationName, actorID string) []*limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	limits := []*limit{l.global}
+	if op, ok := l.ops[operationName]; ok {
+		limits = append(limits, op)
+	}
+	if actorID != "" && (l.opts.actorRate != nil || l.opts.actorMaxInFlight > 0) {
+		actor, ok := l.actors[actorID]
+		if !ok {
+			actor = l.newLimit(ScopeActor, actorID, l.opts.actorRate, l.opts.actorMaxInFlight)
+			l.actors[actorID] = actor
+		}
+		limits = append(limits, actor)
+	}
+
+	for _, lim := range limits {
+		lim.users++
+	}
+	return limits
+}
+
+// leave stops counting a request as a user of the limits
+func (l *Limiter) leave(limits []*limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, lim := range limits {
+		lim.users--
+	}
+}
+
+// sweep drops the limits of idle actors, which are created again on their next request. It must be called holding
+// l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	for actorID, lim := range l.actors {
+		if lim.idle(now) {
+			delete(l.actors, actorID)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Acquire waits until the limits of the given operation and actor allow a request, or fails with a *LimitError. The
+// returned function must be called once the request is done.
+func (l *Limiter) Acquire(ctx context.Context, operationName, actorID string) (release func(), err error) {
+	limits := l.limits(operationName, actorID)
+	if err := l.take(ctx, limits); err != nil {
+		l.leave(limits)
+		return nil, err
+	}
+
+	acquired := 0
+	release = func() {
+		for _, lim := range limits[:acquired] {
+			if lim.slots != nil {
+				<-lim.slots
+			}
+		}
+		l.leave(limits)
+	}
+	for _, lim := range limits {
+		if lim.slots != nil {
+			if err := l.enter(ctx, lim); err != nil {
+				release()
+				return nil, err
+			}
+		}
+		acquired++
+	}
+	return release, nil
+}
+
+// take takes a token from the bucket of each limit, reserving them and waiting for them when they aren't available
+// and fail fast isn't set
+func (l *Limiter) take(ctx context.Context, limits []*limit) error {
+	l.mu.Lock()
+	now := l.now()
+	var wait time.Duration
+	var waitErr error
+	for _, lim := range limits {
+		if lim.bucket == nil {
+			continue
+		}
+		lim.bucket.refill(now)
+		if w := lim.bucket.wait(); w > wait {
+			wait = w
+			waitErr = lim.rateError(w)
+		}
+	}
+
+	if wait > 0 {
+		if l.opts.failFast {
+			l.mu.Unlock()
+			return waitErr
+		}
+		if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+			l.mu.Unlock()
+			return waitErr
+		}
+	}
+	for _, lim := range limits {
+		if lim.bucket != nil {
+			lim.bucket.tokens--
+		}
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved tokens back
+		l.mu.Lock()
+		for _, lim := range limits {
+			if lim.bucket != nil {
+				lim.bucket.tokens++
+			}
+		}
+		l.mu.Unlock()
+		return errors.Join(waitErr, ctx.Err())
+	}
+}
+
+// enter takes an in-flight slot of the limit, waiting for one when fail fast isn't set
+func (l *Limiter) enter(ctx context.Context, lim *limit) error {
+	if l.opts.failFast {
+		select {
+		case lim.slots <- struct{}{}:
+			return nil
+		default:
+			return lim.inFlightError()
+		}
+	}
+
+	select {
+	case lim.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Join(lim.inFlightError(), ctx.Err())
+	}
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateFailFast(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(WithActorRate(1, 2), WithFailFast())
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(context.TODO(), "GetThing", "noisy")
+		assert.NoError(t, err)
+		release()
+	}
+	_, err := l.Acquire(context.TODO(), "GetThing", "noisy")
+	assert.ErrorIs(t, err, ErrLimited)
+	var limitErr *LimitError
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, LimitError{Scope: ScopeActor, Key: "noisy", RetryAfter: time.Second}, *limitErr)
+	}
+
+	// other actors have their own bucket, and actors without user id aren't limited
+	_, err = l.Acquire(context.TODO(), "GetThing", "quiet")
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = l.Acquire(context.TODO(), "GetThing", "")
+		assert.NoError(t, err)
+	}
+
+	now = now.Add(time.Second)
+	_, err = l.Acquire(context.TODO(), "GetThing", "noisy")
+	assert.NoError(t, err)
+}
+
+func TestIdleLimitsDropped(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(WithActorRate(1, 1), WithActorMaxInFlight(1), WithOperationMaxInFlight("GetThing", 5), WithFailFast())
+	l.now = func() time.Time { return now }
+
+	busy, err := l.Acquire(context.TODO(), "GetThing", "busy")
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		release, err := l.Acquire(context.TODO(), "Other", uuid.NewString())
+		assert.NoError(t, err)
+		release()
+	}
+	assert.Len(t, l.actors, 101)
+	assert.Len(t, l.ops, 1, "operations without limits don't get one")
+
+	// once their bucket is full again, actors without requests in flight are dropped on the next sweep
+	now = now.Add(sweepInterval)
+	_, err = l.Acquire(context.TODO(), "GetThing", "busy")
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.Len(t, l.actors, 1)
+
+	busy()
+	now = now.Add(sweepInterval)
+	release, err := l.Acquire(context.TODO(), "GetThing", "other")
+	assert.NoError(t, err)
+	release()
+	assert.Len(t, l.actors, 1)
+	assert.Contains(t, l.actors, "other")
+}
+
+func TestRateWait(t *testing.T) {
+	l := NewLimiter(WithOperationRate("GetThing", 50, 1))
+
+	_, err := l.Acquire(context.TODO(), "GetThing", "")
+	assert.NoError(t, err)
+	start := time.Now()
+	_, err = l.Acquire(context.TODO(), "GetThing", "")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+
+	// requests that can't be sent before their deadline fail right away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, "GetThing", "")
+	var limitErr *LimitError
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, ScopeOperation, limitErr.Scope)
+		assert.Equal(t, "GetThing", limitErr.Key)
+	}
+
+	// other operations aren't limited
+	_, err = l.Acquire(ctx, "OtherThing", "")
+	assert.NoError(t, err)
+}
+
+func TestMaxInFlight(t *testing.T) {
+	l := NewLimiter(WithMaxInFlight(2), WithActorMaxInFlight(1))
+
+	release, err := l.Acquire(context.TODO(), "GetThing", "user")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, "GetThing", "user")
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	other, err := l.Acquire(context.TODO(), "GetThing", "other")
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Acquire(context.TODO(), "GetThing", "third")
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("request sent over the global limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	assert.NoError(t, <-done)
+	other()
+}
+
+func TestWrap(t *testing.T) {
+	srv := gqltest.NewServer(t)
+	srv.OnDocument("thing").Returns(`{"thing": {"field": 1}}`)
+
+	l := NewLimiter(WithActorRate(0.001, 1), WithFailFast())
+	noisyID, quietID := uuid.New(), uuid.New()
+	noisy := l.Wrap(gql.NewClient(srv.Endpoint(), "secret", gql.NewUserActor(&noisyID, "noisy@foo.bar"), "test"))
+	quiet := l.Wrap(gql.NewClient(srv.Endpoint(), "secret", gql.NewUserActor(&quietID, "quiet@foo.bar"), "test"))
+
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	assert.NoError(t, noisy.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	err := noisy.NamedQuery(context.TODO(), "GetThing", &query, nil)
+	assert.True(t, errors.Is(err, ErrLimited))
+	assert.NoError(t, quiet.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	assert.Len(t, srv.Requests(), 2)
+}
@@ -1,10 +1,13 @@
 package gql
 
 import (
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/boolexpr"
 	"github.com/hasura/hge-go-gql-client/util"
 )
 
@@ -16,10 +19,18 @@ const (
 
 // Actor denotes who is making a request to our APIs
 type Actor struct {
-	Role     string
-	UserID   *uuid.UUID
-	Email    string
-	UsesSAML bool
+	// Role is the default role of the actor, the one its requests are made with
+	Role string
+	// AllowedRoles are the other roles the actor can make requests with. They're sent, along with the default role,
+	// as x-hasura-allowed-roles.
+	AllowedRoles []string
+	UserID       *uuid.UUID
+	Email        string
+	UsesSAML     bool
+	// SessionVariables are sent to HGE as headers along with the role, user id and email, which they can't override:
+	// the headers set by the client itself are never sent from them. Names are lower case and start with x-hasura-;
+	// use the setters to keep them so.
+	SessionVariables map[string]string
 }
 
 func NewAdminActor() *Actor {
@@ -28,6 +39,13 @@ func NewAdminActor() *Actor {
 	}
 }
 
+// NewPublicActor returns an anonymous actor, whose requests are sent with the public role and without user headers
+func NewPublicActor() *Actor {
+	return &Actor{
+		Role: RolePublic,
+	}
+}
+
 func NewUserActor(userID *uuid.UUID, email string) *Actor {
 	return &Actor{
 		Role:   RoleUser,
@@ -44,13 +62,164 @@ func (a *Actor) IsAdmin() bool {
 	return a.HasRole(RoleAdmin)
 }
 
+func (a *Actor) IsPublic() bool {
+	return a.HasRole(RolePublic)
+}
+
+// Roles returns the default role of the actor followed by its allowed roles
+func (a *Actor) Roles() []string {
+	return append([]string{a.Role}, a.AllowedRoles...)
+}
+
+// CanActAs tells whether the actor can make requests with the given role: admins can use any role, and other actors
+// their default and allowed roles.
+func (a *Actor) CanActAs(role string) bool {
+	if a.IsAdmin() {
+		return true
+	}
+	for _, r := range a.Roles() {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// canSwitchTo tells whether the actor's requests can be sent with the given role as they are. Admin requests are only
+// sent for admins: other actors allowed to act as admins must be elevated first, so their requests are audited.
+func (a *Actor) canSwitchTo(role string) bool {
+	if strings.EqualFold(role, RoleAdmin) {
+		return a.IsAdmin()
+	}
+	return a.CanActAs(role)
+}
+
+// Satisfies tells whether any of the actor roles is at least as privileged as the given role in
+// DefaultRoleHierarchy
+func (a *Actor) Satisfies(role string) bool {
+	return DefaultRoleHierarchy.Satisfies(a, role)
+}
+
+// WithRole returns a copy of the actor using the given role as default role. The previous default role becomes one
+// of the allowed roles, so the actor can switch back to it.
+func (a *Actor) WithRole(role string) *Actor {
+	actor := *a
+	actor.Role = role
+	actor.AllowedRoles = nil
+	for _, r := range a.Roles() {
+		if !strings.EqualFold(r, role) && !slices.Contains(actor.AllowedRoles, r) {
+			actor.AllowedRoles = append(actor.AllowedRoles, r)
+		}
+	}
+	actor.SessionVariables = maps.Clone(a.SessionVariables)
+	return &actor
+}
+
+// RoleHierarchy ranks roles by privilege
+type RoleHierarchy struct {
+	ranks map[string]int
+}
+
+// NewRoleHierarchy creates a hierarchy with the given roles, from the most to the least privileged
+func NewRoleHierarchy(roles ...string) RoleHierarchy {
+	ranks := make(map[string]int, len(roles))
+	for i, role := range roles {
+		ranks[strings.ToLower(role)] = len(roles) - i
+	}
+	return RoleHierarchy{ranks: ranks}
+}
+
+// DefaultRoleHierarchy is the hierarchy used by Actor.Satisfies. Services with roles of their own can replace it
+// during their initialization:
+//
+//	gql.DefaultRoleHierarchy = gql.NewRoleHierarchy(gql.RoleAdmin, "owner", gql.RoleUser, gql.RolePublic)
+var DefaultRoleHierarchy = NewRoleHierarchy(RoleAdmin, RoleUser, RolePublic)
+
+// Satisfies tells whether any of the actor roles is at least as privileged as the given role. Roles outside the
+// hierarchy only satisfy themselves.
+func (h RoleHierarchy) Satisfies(actor *Actor, role string) bool {
+	required, ranked := h.ranks[strings.ToLower(role)]
+	for _, r := range actor.Roles() {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+		if rank, ok := h.ranks[strings.ToLower(r)]; ok && ranked && rank >= required {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Actor) AsAdmin() *Actor {
 	return &Actor{
-		Role:     RoleAdmin,
-		UserID:   a.UserID,
-		Email:    a.Email,
-		UsesSAML: a.UsesSAML,
+		Role:             RoleAdmin,
+		AllowedRoles:     slices.Clone(a.AllowedRoles),
+		UserID:           a.UserID,
+		Email:            a.Email,
+		UsesSAML:         a.UsesSAML,
+		SessionVariables: maps.Clone(a.SessionVariables),
+	}
+}
+
+// sessionVariableName normalizes the name of a session variable, adding the x-hasura- prefix if missing
+func sessionVariableName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "x-hasura-") {
+		name = "x-hasura-" + name
+	}
+	return name
+}
+
+// reservedSessionVariables are the headers the client sets itself, which session variables can't set
+var reservedSessionVariables = map[string]bool{
+	XHasuraRole:                         true,
+	XHasuraAllowedRoles:                 true,
+	XHasuraUserID:                       true,
+	XHasuraUserEmail:                    true,
+	strings.ToLower(XHasuraAdminSecret): true,
+	XHasuraElevationID:                  true,
+	XHasuraImpersonatedBy:               true,
+}
+
+// SetSessionVariable sets a session variable, returning the actor. The x-hasura- prefix can be omitted from the name.
+// The headers the client sets itself, like the role, user id, email or admin secret, are ignored: they're set from
+// the actor fields, or when deriving clients.
+func (a *Actor) SetSessionVariable(name, value string) *Actor {
+	name = sessionVariableName(name)
+	if reservedSessionVariables[name] {
+		return a
+	}
+	if a.SessionVariables == nil {
+		a.SessionVariables = map[string]string{}
 	}
+	a.SessionVariables[name] = value
+	return a
+}
+
+// SetUUIDSessionVariable sets a uuid session variable, returning the actor
+func (a *Actor) SetUUIDSessionVariable(name string, value uuid.UUID) *Actor {
+	return a.SetSessionVariable(name, value.String())
+}
+
+// SetBoolSessionVariable sets a boolean session variable, returning the actor
+func (a *Actor) SetBoolSessionVariable(name string, value bool) *Actor {
+	return a.SetSessionVariable(name, strconv.FormatBool(value))
+}
+
+// SetArraySessionVariable sets an array session variable, encoded as a postgres array as HGE expects, returning the
+// actor
+func (a *Actor) SetArraySessionVariable(name string, values []string) *Actor {
+	return a.SetSessionVariable(name, util.StringsToPostgresArray(values))
+}
+
+// SetUUIDArraySessionVariable sets a uuid array session variable, encoded as a postgres array as HGE expects,
+// returning the actor
+func (a *Actor) SetUUIDArraySessionVariable(name string, values []uuid.UUID) *Actor {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = v.String()
+	}
+	return a.SetArraySessionVariable(name, strs)
 }
 
 // Access holds information about what a given actor can access.
@@ -62,16 +231,133 @@ type Access struct {
 	AdminProjectIDs          []uuid.UUID
 }
 
+func toSet(lists ...[]uuid.UUID) map[uuid.UUID]bool {
+	set := map[uuid.UUID]bool{}
+	for _, ids := range lists {
+		for _, id := range ids {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+// CanRead tells whether the actor can read the project: admins can read any project, and other actors the projects
+// they're allowed or admins of.
+//
+// CanRead, CanReadMetrics and IsProjectAdmin scan the project lists on every call, which is fine for a few decisions.
+// Code making many decisions over large lists should use the sets of Index instead.
+func (a *Access) CanRead(projectID uuid.UUID) bool {
+	return a.IsAdmin() || slices.Contains(a.AllowedProjectIDs, projectID) || slices.Contains(a.AdminProjectIDs, projectID)
+}
+
+// CanReadMetrics tells whether the actor can read the metrics of the project: admins can read the metrics of any
+// project, and other actors those of the projects they're allowed metrics for or admins of. Like CanRead, it scans
+// the project lists.
+func (a *Access) CanReadMetrics(projectID uuid.UUID) bool {
+	return a.IsAdmin() || slices.Contains(a.MetricsAllowedProjectIDs, projectID) || slices.Contains(a.AdminProjectIDs, projectID)
+}
+
+// IsProjectAdmin tells whether the actor administers the project, as admins do for any project. Like CanRead, it
+// scans the project lists.
+func (a *Access) IsProjectAdmin(projectID uuid.UUID) bool {
+	return a.IsAdmin() || slices.Contains(a.AdminProjectIDs, projectID)
+}
+
+// FilterAllowed returns the given projects the actor can read, in the same order
+func (a *Access) FilterAllowed(projectIDs []uuid.UUID) []uuid.UUID {
+	return a.Index().FilterAllowed(projectIDs)
+}
+
+// ProjectIndex answers the same questions as Access in constant time, for code making many decisions at once. It's a
+// snapshot of the project lists when it was built with Access.Index.
+type ProjectIndex struct {
+	admin    bool
+	readable map[uuid.UUID]bool
+	metrics  map[uuid.UUID]bool
+	admins   map[uuid.UUID]bool
+}
+
+// Index indexes the project lists of the access. Changes made to the lists afterwards aren't seen by the index.
+func (a *Access) Index() *ProjectIndex {
+	return &ProjectIndex{
+		admin:    a.IsAdmin(),
+		readable: toSet(a.AllowedProjectIDs, a.AdminProjectIDs),
+		metrics:  toSet(a.MetricsAllowedProjectIDs, a.AdminProjectIDs),
+		admins:   toSet(a.AdminProjectIDs),
+	}
+}
+
+// CanRead is Access.CanRead
+func (i *ProjectIndex) CanRead(projectID uuid.UUID) bool {
+	return i.admin || i.readable[projectID]
+}
+
+// CanReadMetrics is Access.CanReadMetrics
+func (i *ProjectIndex) CanReadMetrics(projectID uuid.UUID) bool {
+	return i.admin || i.metrics[projectID]
+}
+
+// IsProjectAdmin is Access.IsProjectAdmin
+func (i *ProjectIndex) IsProjectAdmin(projectID uuid.UUID) bool {
+	return i.admin || i.admins[projectID]
+}
+
+// FilterAllowed is Access.FilterAllowed
+func (i *ProjectIndex) FilterAllowed(projectIDs []uuid.UUID) []uuid.UUID {
+	allowed := make([]uuid.UUID, 0, len(projectIDs))
+	for _, id := range projectIDs {
+		if i.CanRead(id) {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed
+}
+
+// ProjectFilter returns a where clause restricting a query to the projects the actor can read, through the given
+// project id column:
+//
+//	vars := map[string]any{"where": access.ProjectFilter("project_id")}
+//
+// The clause is empty for admins, matching every project.
+func (a *Access) ProjectFilter(column string) map[string]any {
+	if a.IsAdmin() {
+		return map[string]any{}
+	}
+
+	readable := make([]uuid.UUID, 0, len(a.AllowedProjectIDs)+len(a.AdminProjectIDs))
+	seen := map[uuid.UUID]bool{}
+	for _, ids := range [][]uuid.UUID{a.AllowedProjectIDs, a.AdminProjectIDs} {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				readable = append(readable, id)
+			}
+		}
+	}
+	return map[string]any{column: boolexpr.In(readable)}
+}
+
 func NewAccess(actor *Actor) *Access {
 	return &Access{Actor: actor}
 }
 
 // NewAccessFromSessionVariables parses de headers in the given StringMap
-// And builds an Access object based on them
+// And builds an Access object based on them. Names are case insensitive, and project lists missing from the session
+// are left nil.
 func NewAccessFromSessionVariables(sessionVariables util.StringMap) *Access {
+	lowerCased := make(util.StringMap, len(sessionVariables))
+	for name, value := range sessionVariables {
+		lowerCased[strings.ToLower(name)] = value
+	}
+	sessionVariables = lowerCased
+
 	// get allowed project IDs
 	getArrayUUID := func(name string) []uuid.UUID {
-		rawValues, err := util.PostgresArrayToStrings(sessionVariables.Get(name))
+		value, ok := sessionVariables[strings.ToLower(name)]
+		if !ok {
+			return nil
+		}
+		rawValues, err := util.PostgresArrayToStrings(value)
 		if err != nil {
 			return []uuid.UUID{}
 		}
@@ -86,11 +372,24 @@ func NewAccessFromSessionVariables(sessionVariables util.StringMap) *Access {
 
 	usesSAML, _ := strconv.ParseBool(sessionVariables.Get(util.XHasuraIsSAMLUser))
 
+	role := sessionVariables.Get(util.XHasuraRole)
+	var allowedRoles []string
+	if value, ok := sessionVariables[XHasuraAllowedRoles]; ok {
+		roles, _ := util.PostgresArrayToStrings(value)
+		for _, r := range roles {
+			if r = strings.Trim(r, `"`); r != "" && !strings.EqualFold(r, role) {
+				allowedRoles = append(allowedRoles, r)
+			}
+		}
+	}
+
 	access := Access{
 		Actor: &Actor{
-			Role:     sessionVariables.Get(util.XHasuraRole),
-			Email:    sessionVariables.Get(util.XHasuraUserEmail),
-			UsesSAML: usesSAML,
+			Role:             role,
+			AllowedRoles:     allowedRoles,
+			Email:            sessionVariables.Get(util.XHasuraUserEmail),
+			UsesSAML:         usesSAML,
+			SessionVariables: otherSessionVariables(sessionVariables),
 		},
 		AllowedProjectIDs:        getArrayUUID(util.XHasuraAllowedProjectIDs),
 		MetricsAllowedProjectIDs: getArrayUUID(util.XHasuraAllowedMetricsProjectIDs),
@@ -106,3 +405,71 @@ func NewAccessFromSessionVariables(sessionVariables util.StringMap) *Access {
 
 	return &access
 }
+
+// accessSessionVariables are the session variables Access and Actor have fields for
+var accessSessionVariables = map[string]bool{
+	util.XHasuraRole:              true,
+	XHasuraAllowedRoles:           true,
+	util.XHasuraUserID:            true,
+	util.XHasuraUserEmail:         true,
+	util.XHasuraIsSAMLUser:        true,
+	util.XHasuraAllowedProjectIDs: true,
+	strings.ToLower(util.XHasuraAllowedMetricsProjectIDs): true,
+	util.XHasuraAdminProjectIDs:                           true,
+}
+
+// otherSessionVariables returns the x-hasura-* session variables without a field in Access, so they're kept when
+// forwarding the session
+func otherSessionVariables(sessionVariables util.StringMap) map[string]string {
+	var others map[string]string
+	for name, value := range sessionVariables {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "x-hasura-") || accessSessionVariables[name] || reservedSessionVariables[name] {
+			continue
+		}
+		if others == nil {
+			others = map[string]string{}
+		}
+		others[name] = value
+	}
+	return others
+}
+
+// SessionVariables returns the session variables of the access, in the form NewAccessFromSessionVariables parses
+// them, so a session received from HGE can be forwarded as is. Project lists are only included when set.
+func (a *Access) SessionVariables() util.StringMap {
+	sessionVariables := util.StringMap{}
+	for name, value := range a.Actor.SessionVariables {
+		sessionVariables[name] = value
+	}
+
+	sessionVariables[util.XHasuraRole] = a.Role
+	if len(a.AllowedRoles) > 0 {
+		sessionVariables[XHasuraAllowedRoles] = util.StringsToPostgresArray(a.Roles())
+	}
+	if a.UserID != nil {
+		sessionVariables[util.XHasuraUserID] = a.UserID.String()
+	}
+	if a.Email != "" {
+		sessionVariables[util.XHasuraUserEmail] = a.Email
+	}
+	if a.UsesSAML {
+		sessionVariables[util.XHasuraIsSAMLUser] = strconv.FormatBool(a.UsesSAML)
+	}
+
+	setArray := func(name string, ids []uuid.UUID) {
+		if ids == nil {
+			return
+		}
+		values := make([]string, len(ids))
+		for i, id := range ids {
+			values[i] = id.String()
+		}
+		sessionVariables[name] = util.StringsToPostgresArray(values)
+	}
+	setArray(util.XHasuraAllowedProjectIDs, a.AllowedProjectIDs)
+	setArray(strings.ToLower(util.XHasuraAllowedMetricsProjectIDs), a.MetricsAllowedProjectIDs)
+	setArray(util.XHasuraAdminProjectIDs, a.AdminProjectIDs)
+
+	return sessionVariables
+}
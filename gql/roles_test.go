@@ -0,0 +1,228 @@
+package gql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/hasura/hge-go-gql-client/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionVariables(t *testing.T) {
+	userID, orgID, projectID := uuid.New(), uuid.New(), uuid.New()
+	actor := NewUserActor(&userID, "foo@bar.baz").
+		SetUUIDSessionVariable("Org-Id", orgID).
+		SetSessionVariable("x-hasura-tenant", "acme").
+		SetBoolSessionVariable("beta", true).
+		SetUUIDArraySessionVariable("x-hasura-allowed-project-ids", []uuid.UUID{projectID}).
+		SetArraySessionVariable("X-Hasura-Tags", []string{"a", "b c"}).
+		SetSessionVariable("role", RoleAdmin)
+
+	assert.Equal(t, map[string]string{
+		"x-hasura-org-id":              orgID.String(),
+		"x-hasura-tenant":              "acme",
+		"x-hasura-beta":                "true",
+		"x-hasura-allowed-project-ids": "{" + projectID.String() + "}",
+		"x-hasura-tags":                `{a,"b c"}`,
+	}, actor.SessionVariables, "the headers set by the client are ignored")
+
+	// session variables can't override the actor identity
+	headers := HeadersFor(actor, "secret", "test-client")
+	assert.Equal(t, RoleUser, headers[XHasuraRole])
+	assert.Equal(t, userID.String(), headers[XHasuraUserID])
+	assert.Equal(t, "acme", headers["x-hasura-tenant"])
+	assert.Equal(t, "{"+projectID.String()+"}", headers["x-hasura-allowed-project-ids"])
+
+	// nor the headers the client sets, even when the actor has no value for them
+	anonymous := &Actor{Role: RoleUser, SessionVariables: map[string]string{
+		XHasuraUserID:                       userID.String(),
+		strings.ToLower(XHasuraAdminSecret): "forged",
+		XHasuraElevationID:                  "forged",
+		XHasuraImpersonatedBy:               "forged",
+	}}
+	assert.Equal(t, map[string]string{
+		XHasuraRole:        RoleUser,
+		XHasuraUserEmail:   "",
+		HasuraClientName:   "test-client",
+		XHasuraAdminSecret: "secret",
+	}, HeadersFor(anonymous, "secret", "test-client"))
+
+	admin := actor.AsAdmin()
+	assert.Equal(t, actor.SessionVariables, admin.SessionVariables)
+	admin.SetSessionVariable("tenant", "other")
+	assert.Equal(t, "acme", actor.SessionVariables["x-hasura-tenant"])
+}
+
+func TestAccessSessionVariables(t *testing.T) {
+	userID, projectID, adminProjectID := uuid.New(), uuid.New(), uuid.New()
+	sessionVariables := util.StringMap{
+		util.XHasuraRole:              RoleUser,
+		util.XHasuraUserID:            userID.String(),
+		util.XHasuraUserEmail:         "foo@bar.baz",
+		util.XHasuraIsSAMLUser:        "true",
+		util.XHasuraAllowedProjectIDs: "{" + projectID.String() + "," + adminProjectID.String() + "}",
+		// as HGE sends it, in lower case
+		"x-hasura-allowed-metrics-project-ids": "{" + projectID.String() + "}",
+		util.XHasuraAdminProjectIDs:            "{" + adminProjectID.String() + "}",
+		"x-hasura-tenant-id":                   "acme",
+	}
+
+	access := NewAccessFromSessionVariables(sessionVariables)
+	assert.Equal(t, map[string]string{"x-hasura-tenant-id": "acme"}, access.Actor.SessionVariables)
+	assert.Equal(t, []uuid.UUID{projectID}, access.MetricsAllowedProjectIDs)
+	assert.Equal(t, sessionVariables, access.SessionVariables())
+	assert.Equal(t, access, NewAccessFromSessionVariables(access.SessionVariables()))
+
+	assert.Equal(t, util.StringMap{util.XHasuraRole: RoleAdmin}, NewAccess(NewAdminActor()).SessionVariables())
+
+	// missing project lists aren't forwarded as empty ones
+	partial := NewAccessFromSessionVariables(util.StringMap{"X-Hasura-Role": RoleUser, "X-Hasura-Admin-Project-Ids": "{}"})
+	assert.Nil(t, partial.AllowedProjectIDs)
+	assert.Nil(t, partial.MetricsAllowedProjectIDs)
+	assert.Equal(t, []uuid.UUID{}, partial.AdminProjectIDs)
+	assert.Equal(t, util.StringMap{util.XHasuraRole: RoleUser, util.XHasuraAdminProjectIDs: "{}"}, partial.SessionVariables())
+
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+	cl := NewClientFromAccess(ts.Endpoint(), "admin-secret", access, "test-client")
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+	assert.NoError(t, cl.Query(context.TODO(), &query, nil))
+
+	h := ts.Requests()[0].Headers
+	for name, value := range sessionVariables {
+		assert.Equal(t, value, h.Get(name), name)
+	}
+}
+
+func TestAccessDecisions(t *testing.T) {
+	userID := uuid.New()
+	allowed, metrics, administered, other := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	access := NewAccess(NewUserActor(&userID, "foo@bar.baz"))
+	access.AllowedProjectIDs = []uuid.UUID{allowed, administered}
+	access.MetricsAllowedProjectIDs = []uuid.UUID{metrics}
+	access.AdminProjectIDs = []uuid.UUID{administered}
+
+	for _, tC := range []struct {
+		project                          uuid.UUID
+		canRead, canReadMetrics, isAdmin bool
+	}{
+		{project: allowed, canRead: true},
+		{project: metrics, canReadMetrics: true},
+		{project: administered, canRead: true, canReadMetrics: true, isAdmin: true},
+		{project: other},
+	} {
+		assert.Equal(t, tC.canRead, access.CanRead(tC.project))
+		assert.Equal(t, tC.canReadMetrics, access.CanReadMetrics(tC.project))
+		assert.Equal(t, tC.isAdmin, access.IsProjectAdmin(tC.project))
+
+		index := access.Index()
+		assert.Equal(t, tC.canRead, index.CanRead(tC.project))
+		assert.Equal(t, tC.canReadMetrics, index.CanReadMetrics(tC.project))
+		assert.Equal(t, tC.isAdmin, index.IsProjectAdmin(tC.project))
+	}
+
+	// decisions follow changes to the lists
+	access.AllowedProjectIDs = append(access.AllowedProjectIDs, other)
+	assert.True(t, access.CanRead(other))
+	access.AllowedProjectIDs = access.AllowedProjectIDs[:2]
+
+	assert.Equal(t, []uuid.UUID{administered, allowed}, access.FilterAllowed([]uuid.UUID{other, administered, metrics, allowed}))
+	assert.Equal(t, map[string]any{"project_id": map[string]any{"_in": []uuid.UUID{allowed, administered}}}, access.ProjectFilter("project_id"))
+	assert.Equal(t, map[string]any{"project_id": map[string]any{"_in": []uuid.UUID{}}}, NewAccess(NewUserActor(&userID, "")).ProjectFilter("project_id"))
+
+	admin := NewAccess(NewAdminActor())
+	assert.True(t, admin.CanRead(other))
+	assert.True(t, admin.CanReadMetrics(other))
+	assert.True(t, admin.IsProjectAdmin(other))
+	assert.Equal(t, []uuid.UUID{other}, admin.FilterAllowed([]uuid.UUID{other}))
+	assert.Empty(t, admin.ProjectFilter("project_id"))
+}
+
+func TestRoles(t *testing.T) {
+	userID := uuid.New()
+	actor := NewUserActor(&userID, "foo@bar.baz")
+	actor.AllowedRoles = []string{"reader"}
+
+	assert.True(t, actor.CanActAs(RoleUser))
+	assert.True(t, actor.CanActAs("Reader"))
+	assert.False(t, actor.CanActAs(RoleAdmin))
+	assert.True(t, NewAdminActor().CanActAs("reader"))
+
+	assert.True(t, actor.Satisfies(RoleUser))
+	assert.True(t, actor.Satisfies(RolePublic))
+	assert.True(t, actor.Satisfies("reader"))
+	assert.False(t, actor.Satisfies(RoleAdmin))
+	assert.False(t, actor.Satisfies("owner"))
+	assert.True(t, NewAdminActor().Satisfies(RoleUser))
+	assert.False(t, NewPublicActor().Satisfies(RoleUser))
+
+	hierarchy := NewRoleHierarchy(RoleAdmin, "owner", RoleUser, RolePublic)
+	owner := &Actor{Role: "owner", UserID: &userID}
+	assert.True(t, hierarchy.Satisfies(owner, RoleUser))
+	assert.False(t, hierarchy.Satisfies(owner, RoleAdmin))
+	assert.False(t, DefaultRoleHierarchy.Satisfies(owner, RoleUser))
+	assert.True(t, hierarchy.Satisfies(actor, "reader"))
+}
+
+func TestClientWithRole(t *testing.T) {
+	ts := gqltest.NewServer(t)
+	ts.OnDocument("thing").Returns(`{"thing":{"field":1}}`)
+	var query struct {
+		Thing struct {
+			Field int `graphql:"field"`
+		} `graphql:"thing"`
+	}
+
+	userID := uuid.New()
+	actor := NewUserActor(&userID, "foo@bar.baz")
+	actor.AllowedRoles = []string{"reader"}
+	user := NewClient(ts.Endpoint(), "admin-secret", actor, "test-client")
+
+	reader, err := user.WithRole("reader")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleUser, user.Actor.Role)
+	assert.Equal(t, []string{RoleUser}, reader.Actor.AllowedRoles)
+	assert.NoError(t, reader.NamedQuery(context.TODO(), "GetThing", &query, nil))
+	_, err = user.WithRole(RoleAdmin)
+	assert.ErrorIs(t, err, ErrRoleNotAllowed)
+
+	// the previous role is still allowed
+	back, err := reader.WithRole(RoleUser)
+	assert.NoError(t, err)
+	assert.Equal(t, actor.Roles(), back.Actor.Roles())
+	assert.True(t, reader.Actor.CanActAs(RoleUser))
+
+	// actors allowed to act as admins must be elevated instead
+	allowedAdmin := NewUserActor(&userID, "foo@bar.baz")
+	allowedAdmin.AllowedRoles = []string{RoleAdmin}
+	_, err = NewClient(ts.Endpoint(), "admin-secret", allowedAdmin, "test-client").WithRole(RoleAdmin)
+	assert.ErrorIs(t, err, ErrRoleNotAllowed)
+	admin, err := NewAdminClient(ts.Endpoint(), "admin-secret", "test-client").WithRole("reader")
+	assert.NoError(t, err)
+	assert.Equal(t, "reader", admin.Actor.Role)
+
+	public := NewClient(ts.Endpoint(), "admin-secret", NewPublicActor().SetSessionVariable("user-id", userID.String()), "test-client")
+	assert.NoError(t, public.NamedQuery(context.TODO(), "GetPublicThing", &query, nil))
+
+	r := ts.RequestsFor("GetThing")[0]
+	assert.Equal(t, "reader", r.Role)
+	assert.Equal(t, userID.String(), r.UserID)
+	assert.Equal(t, "{reader,user}", r.Headers.Get(XHasuraAllowedRoles))
+
+	// allowed roles round trip through the session
+	access := NewAccessFromSessionVariables(util.StringMap{XHasuraRole: "reader", XHasuraAllowedRoles: "{reader,user}"})
+	assert.Equal(t, []string{RoleUser}, access.AllowedRoles)
+	assert.Equal(t, "{reader,user}", access.SessionVariables()[XHasuraAllowedRoles])
+
+	r = ts.RequestsFor("GetPublicThing")[0]
+	assert.Equal(t, RolePublic, r.Role)
+	assert.Empty(t, r.Headers.Values(XHasuraUserID))
+	assert.Empty(t, r.Headers.Values(XHasuraUserEmail))
+}
@@ -0,0 +1,306 @@
+// Package vcr records the graphql traffic of gql clients to golden files and replays it deterministically, so suites
+// that need a real HGE can run offline.
+//
+//	rec := vcr.ForTest(t, "testdata/users.json")
+//	client := gql.NewAdminClient(endpoint, secret, "test", gql.WithRoundTripper(rec))
+//
+// Only the typed clients go through the recorder, the integration Untyped() client is not recorded.
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ErrUnmatchedRequest is returned when replaying a request that was not recorded
+var ErrUnmatchedRequest = errors.New("vcr: no recorded interaction matches the request")
+
+// ModeEnv is the environment variable ForTest reads the mode from: record, replay or passthrough
+const ModeEnv = "GQL_VCR_MODE"
+
+type Mode int
+
+const (
+	// ModeReplay serves the recorded responses, failing on requests that were not recorded
+	ModeReplay Mode = iota
+	// ModeRecord sends the requests to the server and records them, replacing the golden file on Save
+	ModeRecord
+	// ModePassthrough sends the requests to the server without recording them
+	ModePassthrough
+)
+
+// ParseMode parses the name of a mode, as set in ModeEnv
+func ParseMode(mode string) (Mode, error) {
+	switch strings.ToLower(mode) {
+	case "", "replay":
+		return ModeReplay, nil
+	case "record":
+		return ModeRecord, nil
+	case "passthrough":
+		return ModePassthrough, nil
+	}
+	return ModeReplay, fmt.Errorf("vcr: unknown mode %q", mode)
+}
+
+// strippedHeaders are never recorded: secrets, trace headers, ids changing on every run and transport details
+var strippedHeaders = map[string]bool{
+	"X-Hasura-Admin-Secret": true,
+	// a new elevation id is generated every time a client is elevated
+	"X-Hasura-Elevation-Id": true,
+	"Authorization":         true,
+	"Cookie":                true,
+	"Traceparent":           true,
+	"Tracestate":            true,
+	"Baggage":               true,
+	"Content-Length":        true,
+	"Content-Type":          true,
+	"Accept-Encoding":       true,
+	"User-Agent":            true,
+}
+
+// Request is a recorded request, normalized so it can be compared with the requests being replayed
+type Request struct {
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	OperationName string            `json:"operationName,omitempty"`
+	Query         string            `json:"query"`
+	Variables     map[string]any    `json:"variables,omitempty"`
+}
+
+// Response is a recorded response
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+// Interaction is a request and the response it got
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Recorder is an http.RoundTripper recording or replaying the requests sent through it, see gql.WithRoundTripper
+type Recorder struct {
+	path string
+	mode Mode
+	rt   http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     []bool
+}
+
+// New creates a recorder for the given golden file. When replaying, the file must exist. Recorded requests are sent
+// through http.DefaultTransport.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode, rt: http.DefaultTransport}
+	if mode != ModeReplay {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("vcr: reading golden file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, fmt.Errorf("vcr: parsing golden file %s: %w", path, err)
+	}
+	r.replayed = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// ForTest creates a recorder for the given golden file, using the mode set in ModeEnv (replay by default), and saves
+// it when the test finishes.
+func ForTest(t testing.TB, path string) *Recorder {
+	t.Helper()
+	mode, err := ParseMode(os.Getenv(ModeEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(path, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := r.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+	return r
+}
+
+// Save writes the recorded interactions to the golden file. It does nothing unless recording.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch r.mode {
+	case ModePassthrough:
+		return r.rt.RoundTrip(req)
+	case ModeRecord:
+		return r.record(req)
+	default:
+		return r.replay(req)
+	}
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	recorded, err := normalize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	headers := map[string]string{}
+	for name := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-hasura-") || name == "Cache-Control" {
+			headers[name] = resp.Header.Get(name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Request:  recorded,
+		Response: Response{Status: resp.StatusCode, Headers: headers, Body: string(body)},
+	})
+	return resp, nil
+}
+
+// replay serves the first recorded interaction matching the request that was not replayed yet. Once all of the
+// matching interactions were replayed, the last one is served again.
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	recorded, err := normalize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, interaction := range r.interactions {
+		if !interaction.Request.equal(recorded) {
+			continue
+		}
+		match = i
+		if !r.replayed[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("%w: %s %s operation %q, headers %v, variables %v, query %s", ErrUnmatchedRequest,
+			recorded.Method, recorded.Path, recorded.OperationName, recorded.Headers, recorded.Variables, recorded.Query)
+	}
+	r.replayed[match] = true
+
+	recordedResp := r.interactions[match].Response
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", recordedResp.Status, http.StatusText(recordedResp.Status)),
+		StatusCode:    recordedResp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(recordedResp.Body)),
+		ContentLength: int64(len(recordedResp.Body)),
+		Request:       req,
+	}
+	for name, value := range recordedResp.Headers {
+		resp.Header.Set(name, value)
+	}
+	return resp, nil
+}
+
+// normalize reads the request into its recorded form, leaving the body untouched for the request to be sent
+func normalize(req *http.Request) (Request, error) {
+	recorded := Request{
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Headers: map[string]string{},
+	}
+	for name := range req.Header {
+		if !strippedHeaders[http.CanonicalHeaderKey(name)] {
+			recorded.Headers[strings.ToLower(name)] = req.Header.Get(name)
+		}
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return recorded, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return recorded, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	var in struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return recorded, fmt.Errorf("vcr: parsing graphql request: %w", err)
+	}
+	recorded.Query = in.Query
+	recorded.OperationName = in.OperationName
+	if len(in.Variables) > 0 {
+		recorded.Variables = in.Variables
+	}
+	return recorded, nil
+}
+
+func (r Request) equal(other Request) bool {
+	if r.Method != other.Method || r.Path != other.Path || r.OperationName != other.OperationName ||
+		r.Query != other.Query || len(r.Headers) != len(other.Headers) {
+		return false
+	}
+	// maps are marshalled with sorted keys, so this compares variables regardless of their order
+	vars, _ := json.Marshal(r.Variables)
+	otherVars, _ := json.Marshal(other.Variables)
+	if !bytes.Equal(vars, otherVars) {
+		return false
+	}
+	for name, value := range r.Headers {
+		if other.Headers[name] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package vcr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hasura/hge-go-gql-client/gql"
+	"github.com/hasura/hge-go-gql-client/gql/gqltest"
+	"github.com/stretchr/testify/assert"
+)
+
+type userQuery struct {
+	User struct {
+		Name string `graphql:"name"`
+	} `graphql:"users_by_pk(id: $id)"`
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "users.json")
+	userID := uuid.New()
+
+	srv := gqltest.NewServer(t)
+	srv.OnOperation("GetUser").Returns(`{"users_by_pk": {"name": "foo"}}`)
+
+	rec, err := New(path, ModeRecord)
+	assert.NoError(t, err)
+	cl := gql.NewClient(srv.Endpoint(), "super-secret", gql.NewUserActor(&userID, "foo@bar.baz"), "test", gql.WithRoundTripper(rec))
+
+	var q userQuery
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+	assert.NoError(t, rec.Save())
+
+	golden, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(golden), "super-secret")
+	assert.Contains(t, string(golden), userID.String())
+
+	// replaying doesn't need the server, nor the same secret
+	srv.Close()
+	rec, err = New(path, ModeReplay)
+	assert.NoError(t, err)
+	cl = gql.NewClient("http://offline/v1/graphql", "other-secret", gql.NewUserActor(&userID, "foo@bar.baz"), "test", gql.WithRoundTripper(rec))
+
+	q = userQuery{}
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+	assert.Equal(t, "foo", q.User.Name)
+	// exhausted interactions are replayed again
+	assert.NoError(t, cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+
+	err = cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 2})
+	assert.True(t, errors.Is(err, ErrUnmatchedRequest), err)
+
+	otherUser := uuid.New()
+	cl = gql.NewClient("http://offline/v1/graphql", "other-secret", gql.NewUserActor(&otherUser, "foo@bar.baz"), "test", gql.WithRoundTripper(rec))
+	err = cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1})
+	assert.True(t, errors.Is(err, ErrUnmatchedRequest), "requests made by other actors should not match")
+}
+
+func TestReplayElevatedClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.json")
+	userID := uuid.New()
+
+	srv := gqltest.NewServer(t)
+	srv.OnOperation("GetUser").Returns(`{"users_by_pk": {"name": "foo"}}`)
+
+	rec, err := New(path, ModeRecord)
+	assert.NoError(t, err)
+	cl := gql.NewPromotableClient(srv.Endpoint(), "secret", gql.NewUserActor(&userID, "foo@bar.baz"), "test", gql.WithRoundTripper(rec))
+	var q userQuery
+	assert.NoError(t, cl.ForceAdmin().NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+	assert.NoError(t, rec.Save())
+	srv.AssertCalledAs(t, "GetUser", gql.RoleAdmin)
+
+	// every elevation has a new id, which doesn't prevent replaying
+	rec, err = New(path, ModeReplay)
+	assert.NoError(t, err)
+	cl = gql.NewPromotableClient("http://offline/v1/graphql", "secret", gql.NewUserActor(&userID, "foo@bar.baz"), "test", gql.WithRoundTripper(rec))
+	admin, err := cl.Elevate("replay")
+	assert.NoError(t, err)
+	q = userQuery{}
+	assert.NoError(t, admin.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1}))
+	assert.Equal(t, "foo", q.User.Name)
+
+	// the role still has to match
+	err = cl.NamedQuery(context.TODO(), "GetUser", &q, map[string]any{"id": 1})
+	assert.True(t, errors.Is(err, ErrUnmatchedRequest), err)
+}
+
+func TestReplayMissingGoldenFile(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay)
+	assert.Error(t, err)
+}
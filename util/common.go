@@ -61,6 +61,18 @@ func PostgresArrayToStrings(s string) ([]string, error) {
 	return result, nil
 }
 
+// StringsToPostgresArray converts a string array to a postgres array literal, quoting the values that need it
+func StringsToPostgresArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		if v == "" || strings.EqualFold(v, "null") || strings.ContainsAny(v, "{}, \"\\\t\n") {
+			v = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+		quoted[i] = v
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
 // MapStringToUUID parse array strings to uuids
 func MapStringToUUID(arr []string) ([]uuid.UUID, error) {
 	results := make([]uuid.UUID, len(arr))
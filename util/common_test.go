@@ -194,3 +194,13 @@ func TestPostgresArrayToStrings(t *testing.T) {
 	_, err = PostgresArrayToStrings("{")
 	assert.EqualError(t, err, "invalid Postgres array: {")
 }
+
+func TestStringsToPostgresArray(t *testing.T) {
+	assert.Equal(t, "{}", StringsToPostgresArray(nil))
+	assert.Equal(t, "{a,b,c}", StringsToPostgresArray([]string{"a", "b", "c"}))
+	assert.Equal(t, `{"a,b","say \"hi\"","","NULL","c\\d"}`, StringsToPostgresArray([]string{"a,b", `say "hi"`, "", "NULL", `c\d`}))
+
+	values, err := PostgresArrayToStrings(StringsToPostgresArray([]string{"a", "b", "c"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, values)
+}